@@ -49,10 +49,16 @@ func main() {
 
 	// Step 2: Start listening for notifications
 	fmt.Println("\n=== Starting FCM Listener ===")
+	privateKey, authSecret, err := result.FCMCredentials.Keys.Decode()
+	if err != nil {
+		log.Fatalf("Failed to decode Web Push keys: %v", err)
+	}
+
 	fcmClient := client.NewClient(
 		result.FCMCredentials.GCM.AndroidID,
 		result.FCMCredentials.GCM.SecurityToken,
 		nil,
+		client.WithWebPushKeys(privateKey, authSecret),
 	)
 
 	if err := fcmClient.Connect(); err != nil {
@@ -73,10 +79,14 @@ func main() {
 				fmt.Println("❌ Disconnected from FCM")
 
 			case client.EventDataReceived:
-				handleRustPlusMessage(event.Data)
+				if msg, ok := event.DataMessage(); ok {
+					handleRustPlusMessage(msg)
+				}
 
 			case client.EventNotificationReceived:
-				handleRustPlusNotification(event.Data)
+				if notification, ok := event.Notification(); ok {
+					handleRustPlusNotification(notification)
+				}
 
 			case client.EventError:
 				fmt.Printf("❌ Error: %v\n", event.Data)
@@ -93,59 +103,43 @@ func main() {
 	fcmClient.Close()
 }
 
-func handleRustPlusMessage(data interface{}) {
-	msg, ok := data.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	appData, _ := msg["appData"].(map[string]string)
-
+func handleRustPlusMessage(msg *client.DataMessage) {
 	fmt.Printf("\n🎮 Rust+ Data Message\n")
-	fmt.Printf("├─ Category: %v\n", msg["category"])
-	fmt.Printf("├─ From: %v\n", msg["from"])
+	fmt.Printf("├─ Category: %v\n", msg.Category)
+	fmt.Printf("├─ From: %v\n", msg.From)
 
-	if title, ok := appData["title"]; ok {
+	if title, ok := msg.AppData["title"]; ok {
 		fmt.Printf("├─ Title: %s\n", title)
 	}
 
-	if body, ok := appData["body"]; ok {
+	if body, ok := msg.AppData["body"]; ok {
 		fmt.Printf("├─ Body: %s\n", body)
 	}
 
 	// Check for additional app data
-	for key, value := range appData {
+	for key, value := range msg.AppData {
 		if key != "title" && key != "body" {
 			fmt.Printf("├─ %s: %s\n", key, value)
 		}
 	}
 
-	if rawData, ok := msg["rawData"].([]byte); ok && len(rawData) > 0 {
-		fmt.Printf("└─ Raw Data: %d bytes\n", len(rawData))
+	if len(msg.RawData) > 0 {
+		fmt.Printf("└─ Raw Data: %d bytes\n", len(msg.RawData))
 	} else {
 		fmt.Println("└─ (end)")
 	}
 }
 
-func handleRustPlusNotification(data interface{}) {
-	msg, ok := data.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	appData, _ := msg["appData"].(map[string]string)
-
+func handleRustPlusNotification(notification *client.Notification) {
 	fmt.Printf("\n🔔 Rust+ Notification (Encrypted)\n")
-	fmt.Printf("├─ Category: %v\n", msg["category"])
-	fmt.Printf("├─ From: %v\n", msg["from"])
-
-	// Encrypted notifications contain crypto-key
-	if cryptoKey, ok := appData["crypto-key"]; ok {
-		fmt.Printf("├─ Crypto Key: %s...\n", cryptoKey[:20])
-	}
-
-	if rawData, ok := msg["rawData"].([]byte); ok && len(rawData) > 0 {
-		fmt.Printf("└─ Encrypted Data: %d bytes\n", len(rawData))
+	fmt.Printf("├─ Category: %v\n", notification.Category)
+	fmt.Printf("├─ From: %v\n", notification.From)
+	fmt.Printf("├─ Encoding: %s\n", notification.Encoding)
+
+	if notification.Decrypted != nil {
+		fmt.Printf("└─ Decrypted Data: %s\n", notification.Decrypted)
+	} else if len(notification.RawData) > 0 {
+		fmt.Printf("└─ Encrypted Data: %d bytes\n", len(notification.RawData))
 	} else {
 		fmt.Println("└─ (end)")
 	}
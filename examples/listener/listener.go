@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -16,19 +15,20 @@ import (
 // Use this if you already have androidID and securityToken from a previous registration
 func main() {
 	var androidID, securityToken string
+	var opts []client.ClientOption
 
 	// Try to load from JSON file first (created by register_only.go)
-	if _, err := os.Stat("fcm_credentials.json"); err == nil {
+	store := register.NewFileCredentialStore("fcm_credentials.json")
+	if result, err := store.Load(); err == nil {
 		fmt.Println("Loading credentials from fcm_credentials.json...")
-		data, err := os.ReadFile("fcm_credentials.json")
-		if err == nil {
-			var result register.RegistrationResult
-			if err := json.Unmarshal(data, &result); err == nil {
-				androidID = result.FCMCredentials.GCM.AndroidID
-				securityToken = result.FCMCredentials.GCM.SecurityToken
-				fmt.Printf("✅ Loaded credentials for Steam ID: %s\n\n", result.SteamID)
+		androidID = result.FCMCredentials.GCM.AndroidID
+		securityToken = result.FCMCredentials.GCM.SecurityToken
+		if result.FCMCredentials.Keys.PrivateKey != "" {
+			if privateKey, authSecret, err := result.FCMCredentials.Keys.Decode(); err == nil {
+				opts = append(opts, client.WithWebPushKeys(privateKey, authSecret))
 			}
 		}
+		fmt.Printf("✅ Loaded credentials for Steam ID: %s\n\n", result.SteamID)
 	}
 
 	// If not loaded from file, get from command line
@@ -62,7 +62,7 @@ func main() {
 	}
 
 	// Create FCM client
-	fcmClient := client.NewClient(androidID, securityToken, nil)
+	fcmClient := client.NewClient(androidID, securityToken, nil, opts...)
 
 	if err := fcmClient.Connect(); err != nil {
 		log.Fatalf("Failed to connect to FCM: %v", err)
@@ -82,10 +82,14 @@ func main() {
 				fmt.Println("⚠️  Disconnected from FCM - attempting to reconnect...")
 
 			case client.EventDataReceived:
-				handleRustPlusMessage(event.Data)
+				if msg, ok := event.DataMessage(); ok {
+					handleRustPlusMessage(msg)
+				}
 
 			case client.EventNotificationReceived:
-				handleRustPlusNotification(event.Data)
+				if notification, ok := event.Notification(); ok {
+					handleRustPlusNotification(notification)
+				}
 
 			case client.EventError:
 				fmt.Printf("❌ Error: %v\n", event.Data)
@@ -102,53 +106,41 @@ func main() {
 	fcmClient.Close()
 }
 
-func handleRustPlusMessage(data interface{}) {
-	msg, ok := data.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	appData, _ := msg["appData"].(map[string]string)
-
+func handleRustPlusMessage(msg *client.DataMessage) {
 	fmt.Printf("\n🎮 Rust+ Data Message\n")
-	fmt.Printf("├─ Category: %v\n", msg["category"])
-	fmt.Printf("├─ From: %v\n", msg["from"])
-	fmt.Printf("├─ Persistent ID: %v\n", msg["persistentId"])
+	fmt.Printf("├─ Category: %v\n", msg.Category)
+	fmt.Printf("├─ From: %v\n", msg.From)
+	fmt.Printf("├─ Persistent ID: %v\n", msg.PersistentID)
 
-	if title, ok := appData["title"]; ok {
+	if title, ok := msg.AppData["title"]; ok {
 		fmt.Printf("├─ Title: %s\n", title)
 	}
 
-	if body, ok := appData["body"]; ok {
+	if body, ok := msg.AppData["body"]; ok {
 		fmt.Printf("├─ Body: %s\n", body)
 	}
 
 	// Check for additional app data
-	for key, value := range appData {
+	for key, value := range msg.AppData {
 		if key != "title" && key != "body" {
 			fmt.Printf("├─ %s: %s\n", key, value)
 		}
 	}
 
-	if rawData, ok := msg["rawData"].([]byte); ok && len(rawData) > 0 {
-		fmt.Printf("└─ Raw Data: %d bytes\n", len(rawData))
+	if len(msg.RawData) > 0 {
+		fmt.Printf("└─ Raw Data: %d bytes\n", len(msg.RawData))
 	} else {
 		fmt.Println("└─ (end)")
 	}
 }
 
-func handleRustPlusNotification(data interface{}) {
-	msg, ok := data.(map[string]interface{})
-	if !ok {
-		return
-	}
-
-	appData, _ := msg["appData"].(map[string]string)
+func handleRustPlusNotification(notification *client.Notification) {
+	appData := notification.AppData
 
 	fmt.Printf("\n🔔 Rust+ Notification (Encrypted)\n")
-	fmt.Printf("├─ Category: %v\n", msg["category"])
-	fmt.Printf("├─ From: %v\n", msg["from"])
-	fmt.Printf("├─ Persistent ID: %v\n", msg["persistentId"])
+	fmt.Printf("├─ Category: %v\n", notification.Category)
+	fmt.Printf("├─ From: %v\n", notification.From)
+	fmt.Printf("├─ Persistent ID: %v\n", notification.PersistentID)
 
 	// Encrypted notifications contain crypto-key
 	if cryptoKey, ok := appData["crypto-key"]; ok {
@@ -159,12 +151,12 @@ func handleRustPlusNotification(data interface{}) {
 		fmt.Printf("├─ Salt: %s...\n", salt[:min(20, len(salt))])
 	}
 
-	if encoding, ok := appData["encoding"]; ok {
-		fmt.Printf("├─ Encoding: %s\n", encoding)
-	}
+	fmt.Printf("├─ Encoding: %s\n", notification.Encoding)
 
-	if rawData, ok := msg["rawData"].([]byte); ok && len(rawData) > 0 {
-		fmt.Printf("└─ Encrypted Data: %d bytes\n", len(rawData))
+	if notification.Decrypted != nil {
+		fmt.Printf("└─ Decrypted Data: %s\n", notification.Decrypted)
+	} else if len(notification.RawData) > 0 {
+		fmt.Printf("└─ Encrypted Data: %d bytes\n", len(notification.RawData))
 	} else {
 		fmt.Println("└─ (end)")
 	}
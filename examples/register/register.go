@@ -40,15 +40,10 @@ func main() {
 	fmt.Printf("Expo Push Token: %s\n", result.ExpoPushToken)
 	fmt.Printf("Updated Auth Token: %s\n", result.AuthToken)
 
-	// Save credentials to JSON file
-	jsonOutput, err := result.ToJSONIndent()
-	if err != nil {
-		log.Fatalf("Failed to convert to JSON: %v", err)
-	}
-
+	// Save credentials to JSON file, readable only by the current user
 	filename := "fcm_credentials.json"
-	if err := os.WriteFile(filename, []byte(jsonOutput), 0644); err != nil {
-		log.Fatalf("Failed to write to file: %v", err)
+	if err := register.NewFileCredentialStore(filename).Save(result); err != nil {
+		log.Fatalf("Failed to save credentials: %v", err)
 	}
 
 	fmt.Printf("\n✅ Credentials saved to %s\n", filename)
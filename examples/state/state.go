@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+)
+
+// Example showing the connection state machine, as used by health checks.
+// It runs fully offline against the in-process fake MCS server.
+func main() {
+	server := mcstest.NewServer()
+	defer server.Close()
+
+	fcmClient := client.NewClient("1234", "5678", nil, server.ClientOptions()...)
+
+	// Print every state transition
+	go func() {
+		for event := range fcmClient.Events() {
+			if change, ok := event.StateChange(); ok {
+				fmt.Printf("  %s -> %s\n", change.From, change.To)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fmt.Printf("State before connecting: %s\n", fcmClient.State())
+	if err := fcmClient.ConnectContext(ctx); err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+
+	// A health check only reports ready once the client is logged in
+	if err := fcmClient.Wait(ctx, client.StateConnected); err != nil {
+		log.Fatalf("Client never connected: %v", err)
+	}
+	fmt.Printf("✅ Logged in, state: %s\n", fcmClient.State())
+
+	// Drop the connection from the server side and watch the client recover
+	fmt.Println("Dropping the connection...")
+	conns := server.Conns()
+	conns[len(conns)-1].Close()
+
+	if err := fcmClient.Wait(ctx, client.StateBackoff); err != nil {
+		log.Fatalf("Client did not notice the drop: %v", err)
+	}
+	if err := fcmClient.Wait(ctx, client.StateConnected); err != nil {
+		log.Fatalf("Client did not reconnect: %v", err)
+	}
+	fmt.Printf("✅ Reconnected, state: %s\n", fcmClient.State())
+
+	fcmClient.Close()
+	if err := fcmClient.Wait(ctx, client.StateConnected); err != nil {
+		fmt.Printf("Waiting after Close returns: %v\n", err)
+	}
+	fmt.Printf("State after closing: %s\n", fcmClient.State())
+
+	// Give the printer a moment to drain the last transitions
+	time.Sleep(100 * time.Millisecond)
+}
@@ -40,6 +40,15 @@ const (
 	NumProtoTypes          = 16
 )
 
+// MCS IqStanza extension IDs
+const (
+	SelectiveAckExtension = 12
+	StreamAckExtension    = 13
+)
+
+// Number of received messages after which a StreamAck is sent
+const UnackedMessagesBeforeStreamAck = 10
+
 // MCS Server configuration
 const (
 	MCSHost = "mtalk.google.com"
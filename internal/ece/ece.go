@@ -0,0 +1,275 @@
+package ece
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Content encodings supported by Decrypt
+const (
+	// AES128GCM is the encoding defined by RFC 8188 and used by RFC 8291 Web Push
+	AES128GCM = "aes128gcm"
+	// AESGCM is the legacy encoding from draft-ietf-webpush-encryption-04
+	AESGCM = "aesgcm"
+)
+
+const (
+	keyLen        = 16
+	nonceLen      = 12
+	tagLen        = 16
+	saltLen       = 16
+	authSecretLen = 16
+	// defaultRecordSize is the aesgcm record size when the sender does not specify one
+	defaultRecordSize = 4096
+)
+
+// Keys holds the receiver's key material for decrypting Web Push messages
+type Keys struct {
+	PrivateKey *ecdh.PrivateKey
+	AuthSecret []byte
+}
+
+// Params describes an encrypted Web Push message
+type Params struct {
+	// Encoding is AES128GCM or AESGCM
+	Encoding string
+	// Payload is the encrypted body
+	Payload []byte
+	// CryptoKey is the value of the Crypto-Key header (aesgcm only)
+	CryptoKey string
+	// Encryption is the value of the Encryption header (aesgcm only)
+	Encryption string
+}
+
+// Decrypt decrypts a Web Push message with the receiver's keys
+func Decrypt(keys Keys, params Params) ([]byte, error) {
+	if keys.PrivateKey == nil {
+		return nil, fmt.Errorf("missing private key")
+	}
+	if len(keys.AuthSecret) != authSecretLen {
+		return nil, fmt.Errorf("auth secret must be %d bytes, got %d", authSecretLen, len(keys.AuthSecret))
+	}
+
+	switch params.Encoding {
+	case AES128GCM:
+		return decryptAES128GCM(keys, params.Payload)
+	case AESGCM:
+		return decryptAESGCM(keys, params)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %q", params.Encoding)
+	}
+}
+
+// decryptAES128GCM decrypts a payload encoded as described in RFC 8188 and RFC 8291
+func decryptAES128GCM(keys Keys, payload []byte) ([]byte, error) {
+	// Header: salt (16) | rs (4) | idlen (1) | keyid (idlen)
+	if len(payload) < saltLen+5 {
+		return nil, fmt.Errorf("payload too short for aes128gcm header")
+	}
+	salt := payload[:saltLen]
+	recordSize := int(binary.BigEndian.Uint32(payload[saltLen : saltLen+4]))
+	idLen := int(payload[saltLen+4])
+	headerLen := saltLen + 5 + idLen
+	if len(payload) < headerLen {
+		return nil, fmt.Errorf("payload too short for aes128gcm key ID")
+	}
+	if recordSize <= tagLen+1 {
+		return nil, fmt.Errorf("invalid record size: %d", recordSize)
+	}
+	senderPublic := payload[saltLen+5 : headerLen]
+
+	secret, receiverPublic, err := sharedSecret(keys.PrivateKey, senderPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	// IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0x00 || ua_public || as_public)
+	info := append([]byte("WebPush: info\x00"), receiverPublic...)
+	info = append(info, senderPublic...)
+	ikm := hkdf(keys.AuthSecret, secret, info, 32)
+
+	key := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), keyLen)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), nonceLen)
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var plaintext []byte
+	body := payload[headerLen:]
+	for seq := 0; len(body) > 0; seq++ {
+		n := min(recordSize, len(body))
+		record, err := aead.Open(nil, recordNonce(nonce, seq), body[:n], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt record %d: %w", seq, err)
+		}
+		body = body[n:]
+
+		// Strip the zero padding and the delimiter: 0x02 ends the last record, 0x01 any other
+		end := len(record) - 1
+		for end >= 0 && record[end] == 0 {
+			end--
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("record %d has no delimiter", seq)
+		}
+		last := len(body) == 0
+		if (last && record[end] != 2) || (!last && record[end] != 1) {
+			return nil, fmt.Errorf("record %d has invalid delimiter", seq)
+		}
+		plaintext = append(plaintext, record[:end]...)
+	}
+
+	return plaintext, nil
+}
+
+// decryptAESGCM decrypts a payload using the legacy aesgcm content encoding
+func decryptAESGCM(keys Keys, params Params) ([]byte, error) {
+	senderPublic, err := decodeParam(params.CryptoKey, "dh")
+	if err != nil {
+		return nil, fmt.Errorf("invalid crypto-key: %w", err)
+	}
+	salt, err := decodeParam(params.Encryption, "salt")
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption: %w", err)
+	}
+	if len(salt) != saltLen {
+		return nil, fmt.Errorf("salt must be %d bytes, got %d", saltLen, len(salt))
+	}
+
+	recordSize := defaultRecordSize
+	if rs, ok := lookupParam(params.Encryption, "rs"); ok {
+		if _, err := fmt.Sscanf(rs, "%d", &recordSize); err != nil || recordSize <= 2 {
+			return nil, fmt.Errorf("invalid record size: %q", rs)
+		}
+	}
+
+	secret, receiverPublic, err := sharedSecret(keys.PrivateKey, senderPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	ikm := hkdf(keys.AuthSecret, secret, []byte("Content-Encoding: auth\x00"), 32)
+
+	// context = "P-256" || 0x00 || len(ua_public) || ua_public || len(as_public) || as_public
+	var context bytes.Buffer
+	context.WriteString("P-256\x00")
+	binary.Write(&context, binary.BigEndian, uint16(len(receiverPublic)))
+	context.Write(receiverPublic)
+	binary.Write(&context, binary.BigEndian, uint16(len(senderPublic)))
+	context.Write(senderPublic)
+
+	key := hkdf(salt, ikm, append([]byte("Content-Encoding: aesgcm\x00"), context.Bytes()...), keyLen)
+	nonce := hkdf(salt, ikm, append([]byte("Content-Encoding: nonce\x00"), context.Bytes()...), nonceLen)
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var plaintext []byte
+	body := params.Payload
+	for seq := 0; len(body) > 0; seq++ {
+		n := min(recordSize+tagLen, len(body))
+		record, err := aead.Open(nil, recordNonce(nonce, seq), body[:n], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt record %d: %w", seq, err)
+		}
+		body = body[n:]
+
+		// Each record starts with a two byte padding length followed by the padding
+		if len(record) < 2 {
+			return nil, fmt.Errorf("record %d too short", seq)
+		}
+		padding := int(binary.BigEndian.Uint16(record[:2]))
+		if 2+padding > len(record) {
+			return nil, fmt.Errorf("record %d has invalid padding", seq)
+		}
+		plaintext = append(plaintext, record[2+padding:]...)
+	}
+
+	return plaintext, nil
+}
+
+// sharedSecret computes the ECDH secret with the sender's public key and
+// returns it together with the receiver's encoded public key
+func sharedSecret(private *ecdh.PrivateKey, senderPublic []byte) ([]byte, []byte, error) {
+	public, err := ecdh.P256().NewPublicKey(senderPublic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid sender public key: %w", err)
+	}
+
+	secret, err := private.ECDH(public)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ecdh failed: %w", err)
+	}
+
+	return secret, private.PublicKey().Bytes(), nil
+}
+
+// hkdf derives length bytes (at most 32) using HKDF-SHA256
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
+
+// newGCM creates an AES-GCM cipher for the content encryption key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// recordNonce XORs the record sequence number into the last bytes of the base nonce
+func recordNonce(base []byte, seq int) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(seq))
+	for i := range counter {
+		nonce[len(nonce)-8+i] ^= counter[i]
+	}
+	return nonce
+}
+
+// lookupParam finds name=value in a header like "dh=...;p256ecdsa=..."
+func lookupParam(header, name string) (string, bool) {
+	for _, part := range strings.FieldsFunc(header, func(r rune) bool { return r == ';' || r == ',' }) {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`), true
+		}
+	}
+	return "", false
+}
+
+// decodeParam looks up a base64url encoded header parameter and decodes it
+func decodeParam(header, name string) ([]byte, error) {
+	value, ok := lookupParam(header, name)
+	if !ok {
+		return nil, fmt.Errorf("missing %s parameter", name)
+	}
+	return DecodeBase64URL(value)
+}
+
+// DecodeBase64URL decodes base64url data with or without padding
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -0,0 +1,247 @@
+package ece
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// RFC 8291 section 5 test vector
+const (
+	rfcPlaintext     = "When I grow up, I want to be a watermelon"
+	rfcSenderPrivate = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfcPrivateKey    = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	rfcAuthSecret    = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfcSalt          = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfcMessage       = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+// mustDecode decodes base64url test data
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := DecodeBase64URL(s)
+	if err != nil {
+		t.Fatalf("DecodeBase64URL(%q): %v", s, err)
+	}
+	return data
+}
+
+// privateKey decodes a base64url P-256 private key
+func privateKey(t *testing.T, s string) *ecdh.PrivateKey {
+	t.Helper()
+	key, err := ecdh.P256().NewPrivateKey(mustDecode(t, s))
+	if err != nil {
+		t.Fatalf("NewPrivateKey: %v", err)
+	}
+	return key
+}
+
+// rfcKeys returns the receiver keys of the RFC 8291 test vector
+func rfcKeys(t *testing.T) Keys {
+	return Keys{PrivateKey: privateKey(t, rfcPrivateKey), AuthSecret: mustDecode(t, rfcAuthSecret)}
+}
+
+// encryptAES128GCM builds an aes128gcm payload from records that already carry
+// their padding and delimiter, to craft messages the test vector does not cover
+func encryptAES128GCM(t *testing.T, keys Keys, records [][]byte, recordSize int) []byte {
+	t.Helper()
+	sender := privateKey(t, rfcSenderPrivate)
+	salt := mustDecode(t, rfcSalt)
+
+	secret, err := sender.ECDH(keys.PrivateKey.PublicKey())
+	if err != nil {
+		t.Fatalf("ECDH: %v", err)
+	}
+	senderPublic := sender.PublicKey().Bytes()
+	info := append([]byte("WebPush: info\x00"), keys.PrivateKey.PublicKey().Bytes()...)
+	info = append(info, senderPublic...)
+	ikm := hkdf(keys.AuthSecret, secret, info, 32)
+	aead, err := newGCM(hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), keyLen))
+	if err != nil {
+		t.Fatalf("newGCM: %v", err)
+	}
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), nonceLen)
+
+	payload := append([]byte(nil), salt...)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(recordSize))
+	payload = append(payload, byte(len(senderPublic)))
+	payload = append(payload, senderPublic...)
+	for seq, record := range records {
+		payload = aead.Seal(payload, recordNonce(nonce, seq), record, nil)
+	}
+	return payload
+}
+
+// encryptAESGCM builds a legacy aesgcm message from records that already carry
+// their padding length and padding, returning the payload and both headers
+func encryptAESGCM(t *testing.T, keys Keys, records [][]byte) Params {
+	t.Helper()
+	sender := privateKey(t, rfcSenderPrivate)
+	salt := mustDecode(t, rfcSalt)
+
+	secret, err := sender.ECDH(keys.PrivateKey.PublicKey())
+	if err != nil {
+		t.Fatalf("ECDH: %v", err)
+	}
+	receiverPublic := keys.PrivateKey.PublicKey().Bytes()
+	senderPublic := sender.PublicKey().Bytes()
+	ikm := hkdf(keys.AuthSecret, secret, []byte("Content-Encoding: auth\x00"), 32)
+
+	context := []byte("P-256\x00")
+	context = binary.BigEndian.AppendUint16(context, uint16(len(receiverPublic)))
+	context = append(context, receiverPublic...)
+	context = binary.BigEndian.AppendUint16(context, uint16(len(senderPublic)))
+	context = append(context, senderPublic...)
+
+	aead, err := newGCM(hkdf(salt, ikm, append([]byte("Content-Encoding: aesgcm\x00"), context...), keyLen))
+	if err != nil {
+		t.Fatalf("newGCM: %v", err)
+	}
+	nonce := hkdf(salt, ikm, append([]byte("Content-Encoding: nonce\x00"), context...), nonceLen)
+
+	var payload []byte
+	recordSize := 0
+	for seq, record := range records {
+		payload = aead.Seal(payload, recordNonce(nonce, seq), record, nil)
+		recordSize = max(recordSize, len(record))
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	return Params{
+		Encoding:   AESGCM,
+		Payload:    payload,
+		CryptoKey:  "dh=" + encode(senderPublic) + ";p256ecdsa=unused",
+		Encryption: "salt=" + encode(salt) + ";rs=" + strconv.Itoa(recordSize),
+	}
+}
+
+// aesgcmRecord prefixes data with padding bytes of zero and their two byte length
+func aesgcmRecord(padding int, data string) []byte {
+	record := binary.BigEndian.AppendUint16(nil, uint16(padding))
+	record = append(record, make([]byte, padding)...)
+	return append(record, data...)
+}
+
+func TestDecryptRFC8291Vector(t *testing.T) {
+	plaintext, err := Decrypt(rfcKeys(t), Params{Encoding: AES128GCM, Payload: mustDecode(t, rfcMessage)})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(plaintext) != rfcPlaintext {
+		t.Errorf("plaintext = %q, want %q", plaintext, rfcPlaintext)
+	}
+}
+
+func TestDecryptAES128GCMMultipleRecords(t *testing.T) {
+	keys := rfcKeys(t)
+	// Records of rs-16 bytes: data, delimiter and zero padding
+	payload := encryptAES128GCM(t, keys, [][]byte{
+		[]byte("hello, \x01\x00"),
+		[]byte("world\x02"),
+	}, 9+tagLen)
+
+	plaintext, err := Decrypt(keys, Params{Encoding: AES128GCM, Payload: payload})
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(plaintext) != "hello, world" {
+		t.Errorf("plaintext = %q, want %q", plaintext, "hello, world")
+	}
+}
+
+func TestDecryptAESGCM(t *testing.T) {
+	keys := rfcKeys(t)
+	params := encryptAESGCM(t, keys, [][]byte{
+		aesgcmRecord(3, "legacy "),
+		aesgcmRecord(0, "push"),
+	})
+
+	plaintext, err := Decrypt(keys, params)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if string(plaintext) != "legacy push" {
+		t.Errorf("plaintext = %q, want %q", plaintext, "legacy push")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	keys := rfcKeys(t)
+	message := mustDecode(t, rfcMessage)
+
+	wrongSecret := keys
+	wrongSecret.AuthSecret = bytes.Repeat([]byte{1}, authSecretLen)
+
+	tests := []struct {
+		name   string
+		keys   Keys
+		params Params
+		want   string
+	}{
+		{
+			name:   "wrong auth secret",
+			keys:   wrongSecret,
+			params: Params{Encoding: AES128GCM, Payload: message},
+			want:   "failed to decrypt record 0",
+		},
+		{
+			name:   "truncated record",
+			keys:   keys,
+			params: Params{Encoding: AES128GCM, Payload: message[:len(message)-5]},
+			want:   "failed to decrypt record 0",
+		},
+		{
+			name:   "truncated header",
+			keys:   keys,
+			params: Params{Encoding: AES128GCM, Payload: message[:saltLen+3]},
+			want:   "too short",
+		},
+		{
+			name:   "padding without delimiter",
+			keys:   keys,
+			params: Params{Encoding: AES128GCM, Payload: encryptAES128GCM(t, keys, [][]byte{{0, 0, 0}}, 4096)},
+			want:   "no delimiter",
+		},
+		{
+			name:   "last record with non-final delimiter",
+			keys:   keys,
+			params: Params{Encoding: AES128GCM, Payload: encryptAES128GCM(t, keys, [][]byte{[]byte("data\x01")}, 4096)},
+			want:   "invalid delimiter",
+		},
+		{
+			name: "aesgcm padding longer than record",
+			keys: keys,
+			params: encryptAESGCM(t, keys, [][]byte{
+				append(binary.BigEndian.AppendUint16(nil, 50), "short"...),
+			}),
+			want: "invalid padding",
+		},
+		{
+			name: "aesgcm wrong auth secret",
+			keys: wrongSecret,
+			params: encryptAESGCM(t, keys, [][]byte{
+				aesgcmRecord(0, "data"),
+			}),
+			want: "failed to decrypt record 0",
+		},
+		{
+			name:   "unsupported encoding",
+			keys:   keys,
+			params: Params{Encoding: "aes256gcm", Payload: message},
+			want:   "unsupported content encoding",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decrypt(tt.keys, tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Decrypt error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
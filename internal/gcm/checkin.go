@@ -1,7 +1,9 @@
 package gcm
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -15,20 +17,50 @@ const (
 	registerURL = "https://android.clients.google.com/c2dm/register3"
 )
 
+// Options configures the HTTP requests made to GCM
+type Options struct {
+	// Client is used for every request; a default client is used when nil
+	Client *http.Client
+	// CheckinURL and RegisterURL override the default GCM endpoints when set
+	CheckinURL  string
+	RegisterURL string
+}
+
+func (o Options) checkinURL() string {
+	if o.CheckinURL != "" {
+		return o.CheckinURL
+	}
+	return checkinURL
+}
+
+func (o Options) registerURL() string {
+	if o.RegisterURL != "" {
+		return o.RegisterURL
+	}
+	return registerURL
+}
+
 // CheckIn performs a GCM check-in to get androidId and securityToken
 func CheckIn(androidID, securityToken string) (*pb.AndroidCheckinResponse, error) {
+	return CheckInContext(context.Background(), androidID, securityToken, Options{})
+}
+
+// CheckInContext performs a GCM check-in that is aborted when ctx is cancelled
+func CheckInContext(ctx context.Context, androidID, securityToken string, opts Options) (*pb.AndroidCheckinResponse, error) {
 	buffer, err := getCheckinRequest(androidID, securityToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create checkin request: %w", err)
 	}
 
 	body, err := utils.SimpleRequest(utils.RequestOptions{
-		URL:    checkinURL,
+		URL:    opts.checkinURL(),
 		Method: "POST",
 		Headers: map[string]string{
 			"Content-Type": "application/x-protobuf",
 		},
-		Body: buffer,
+		Body:    buffer,
+		Client:  opts.Client,
+		Context: ctx,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("checkin request failed: %w", err)
@@ -43,8 +75,9 @@ func CheckIn(androidID, securityToken string) (*pb.AndroidCheckinResponse, error
 }
 
 // Register performs FCM registration with the given credentials
-func Register(androidID, securityToken, appID string) (string, error) {
-	serverKey := utils.ToBase64(ServerKey)
+func Register(androidID, securityToken, appID string, opts Options) (string, error) {
+	// Chrome sends the server key as unpadded base64url
+	serverKey := utils.ToBase64URL(ServerKey)
 
 	form := map[string]string{
 		"app":       "org.chromium.linux",
@@ -53,7 +86,7 @@ func Register(androidID, securityToken, appID string) (string, error) {
 		"sender":    serverKey,
 	}
 
-	response, err := postRegister(androidID, securityToken, form, 0)
+	response, err := postRegister(androidID, securityToken, form, opts, 0)
 	if err != nil {
 		return "", err
 	}
@@ -67,15 +100,16 @@ func Register(androidID, securityToken, appID string) (string, error) {
 	return parts[1], nil
 }
 
-func postRegister(androidID, securityToken string, form map[string]string, retryCount int) (string, error) {
+func postRegister(androidID, securityToken string, form map[string]string, opts Options, retryCount int) (string, error) {
 	body, err := utils.SimpleRequest(utils.RequestOptions{
-		URL:    registerURL,
+		URL:    opts.registerURL(),
 		Method: "POST",
 		Headers: map[string]string{
 			"Authorization": fmt.Sprintf("AidLogin %s:%s", androidID, securityToken),
 			"Content-Type":  "application/x-www-form-urlencoded",
 		},
-		Form: form,
+		Form:   form,
+		Client: opts.Client,
 	})
 	if err != nil {
 		return "", fmt.Errorf("register request failed: %w", err)
@@ -88,7 +122,7 @@ func postRegister(androidID, securityToken string, form map[string]string, retry
 		}
 
 		fmt.Printf("Register request failed with %s, retrying... %d\n", response, retryCount+1)
-		return postRegister(androidID, securityToken, form, retryCount+1)
+		return postRegister(androidID, securityToken, form, opts, retryCount+1)
 	}
 
 	return response, nil
@@ -0,0 +1,400 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listen starts a TCP listener on localhost that is closed with the test
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+// serve accepts connections on listener and handles each in its own goroutine
+func serve(listener net.Listener, handle func(net.Conn)) {
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+}
+
+// echoServer starts a server that writes back everything it reads
+func echoServer(t *testing.T) string {
+	listener := listen(t)
+	serve(listener, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+	return listener.Addr().String()
+}
+
+// pipe copies between the client and the target until either side closes
+func pipe(client io.ReadWriter, target net.Conn) {
+	go io.Copy(target, client)
+	io.Copy(client, target)
+}
+
+// connectProxy is an HTTP proxy that only supports CONNECT
+type connectProxy struct {
+	// auth is the required Proxy-Authorization value, if any
+	auth string
+	// status overrides the response to every CONNECT request
+	status int
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// start serves the proxy and returns its URL
+func (p *connectProxy) start(t *testing.T) *url.URL {
+	listener := listen(t)
+	serve(listener, p.handle)
+	return &url.URL{Scheme: "http", Host: listener.Addr().String()}
+}
+
+func (p *connectProxy) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.targets = append(p.targets, req.Host)
+	p.mu.Unlock()
+
+	status := http.StatusOK
+	switch {
+	case req.Method != http.MethodConnect:
+		status = http.StatusMethodNotAllowed
+	case p.status != 0:
+		status = p.status
+	case p.auth != "" && req.Header.Get("Proxy-Authorization") != p.auth:
+		status = http.StatusProxyAuthRequired
+	}
+	resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+	if err := resp.Write(conn); err != nil || status != http.StatusOK {
+		return
+	}
+
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	pipe(struct {
+		io.Reader
+		io.Writer
+	}{reader, conn}, target)
+}
+
+// requested returns the CONNECT targets the proxy has seen
+func (p *connectProxy) requested() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+// socksProxy is a SOCKS5 server that supports the CONNECT command
+type socksProxy struct {
+	// username and password are required when set
+	username, password string
+	// reply overrides the reply code to every CONNECT request
+	reply byte
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// start serves the proxy and returns its URL
+func (p *socksProxy) start(t *testing.T) *url.URL {
+	listener := listen(t)
+	serve(listener, p.handle)
+	return &url.URL{Scheme: "socks5", Host: listener.Addr().String()}
+}
+
+func (p *socksProxy) handle(conn net.Conn) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	want := byte(socksAuthNone)
+	if p.username != "" {
+		want = socksAuthPassword
+	}
+	if !strings.ContainsRune(string(methods), rune(want)) {
+		conn.Write([]byte{socksVersion, socksAuthUnavailable})
+		return
+	}
+	conn.Write([]byte{socksVersion, want})
+
+	if want == socksAuthPassword && !p.authenticate(conn) {
+		return
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		name, err := readLengthPrefixed(conn)
+		if err != nil {
+			return
+		}
+		host = name
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	p.mu.Lock()
+	p.targets = append(p.targets, addr)
+	p.mu.Unlock()
+
+	var target net.Conn
+	reply := p.reply
+	if reply == 0 {
+		var err error
+		if target, err = net.Dial("tcp", addr); err != nil {
+			reply = 0x05 // connection refused
+		} else {
+			defer target.Close()
+		}
+	}
+
+	conn.Write([]byte{socksVersion, reply, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	if reply == 0 {
+		pipe(conn, target)
+	}
+}
+
+// authenticate checks a username/password request (RFC 1929)
+func (p *socksProxy) authenticate(conn net.Conn) bool {
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(conn, version); err != nil {
+		return false
+	}
+	username, err := readLengthPrefixed(conn)
+	if err != nil {
+		return false
+	}
+	password, err := readLengthPrefixed(conn)
+	if err != nil {
+		return false
+	}
+
+	if username != p.username || password != p.password {
+		conn.Write([]byte{0x01, 0x01})
+		return false
+	}
+	conn.Write([]byte{0x01, 0x00})
+	return true
+}
+
+// requested returns the CONNECT targets the proxy has seen
+func (p *socksProxy) requested() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+// readLengthPrefixed reads a string preceded by its one byte length
+func readLengthPrefixed(r io.Reader) (string, error) {
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+	value := make([]byte, length[0])
+	if _, err := io.ReadFull(r, value); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// dial opens a connection to addr through the proxy at proxyURL
+func dial(t *testing.T, proxyURL *url.URL, addr string) (net.Conn, error) {
+	t.Helper()
+	dialer, err := Dialer(proxyURL, (&net.Dialer{}).DialContext)
+	if err != nil {
+		t.Fatalf("Dialer: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return dialer(ctx, "tcp", addr)
+}
+
+// assertEcho checks that conn reaches the echo server
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if string(reply) != "ping" {
+		t.Errorf("echo = %q, want ping", reply)
+	}
+}
+
+// assertErrorContains fails unless err mentions want
+func assertErrorContains(t *testing.T, err error, want string) {
+	t.Helper()
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain %q", err, want)
+	}
+}
+
+func TestDialConnect(t *testing.T) {
+	target := echoServer(t)
+	proxy := &connectProxy{}
+
+	conn, err := dial(t, proxy.start(t), target)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	assertEcho(t, conn)
+
+	if got := proxy.requested(); len(got) != 1 || got[0] != target {
+		t.Errorf("CONNECT targets = %v, want [%s]", got, target)
+	}
+}
+
+func TestDialConnectAuthentication(t *testing.T) {
+	target := echoServer(t)
+	proxy := &connectProxy{auth: "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))}
+	proxyURL := proxy.start(t)
+
+	proxyURL.User = url.UserPassword("user", "secret")
+	conn, err := dial(t, proxyURL, target)
+	if err != nil {
+		t.Fatalf("dial with credentials: %v", err)
+	}
+	assertEcho(t, conn)
+
+	proxyURL.User = url.UserPassword("user", "wrong")
+	_, err = dial(t, proxyURL, target)
+	assertErrorContains(t, err, "proxy refused CONNECT: 407")
+
+	proxyURL.User = nil
+	_, err = dial(t, proxyURL, target)
+	assertErrorContains(t, err, "proxy refused CONNECT: 407")
+}
+
+func TestDialConnectRefused(t *testing.T) {
+	proxy := &connectProxy{status: http.StatusForbidden}
+
+	_, err := dial(t, proxy.start(t), echoServer(t))
+	assertErrorContains(t, err, "proxy refused CONNECT: 403")
+}
+
+func TestDialSOCKS5(t *testing.T) {
+	target := echoServer(t)
+	proxy := &socksProxy{}
+
+	conn, err := dial(t, proxy.start(t), target)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	assertEcho(t, conn)
+
+	if got := proxy.requested(); len(got) != 1 || got[0] != target {
+		t.Errorf("CONNECT targets = %v, want [%s]", got, target)
+	}
+}
+
+func TestDialSOCKS5DomainName(t *testing.T) {
+	_, port, _ := net.SplitHostPort(echoServer(t))
+	proxy := &socksProxy{}
+
+	// The proxy resolves the name, so it must arrive as a domain
+	conn, err := dial(t, proxy.start(t), net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	assertEcho(t, conn)
+
+	if got, want := proxy.requested(), net.JoinHostPort("localhost", port); len(got) != 1 || got[0] != want {
+		t.Errorf("CONNECT targets = %v, want [%s]", got, want)
+	}
+}
+
+func TestDialSOCKS5Authentication(t *testing.T) {
+	target := echoServer(t)
+	proxy := &socksProxy{username: "user", password: "secret"}
+	proxyURL := proxy.start(t)
+
+	proxyURL.User = url.UserPassword("user", "secret")
+	conn, err := dial(t, proxyURL, target)
+	if err != nil {
+		t.Fatalf("dial with credentials: %v", err)
+	}
+	assertEcho(t, conn)
+
+	proxyURL.User = url.UserPassword("user", "wrong")
+	_, err = dial(t, proxyURL, target)
+	assertErrorContains(t, err, "socks5 proxy rejected credentials")
+
+	proxyURL.User = nil
+	_, err = dial(t, proxyURL, target)
+	assertErrorContains(t, err, "accepted none of our authentication methods")
+}
+
+func TestDialSOCKS5ConnectRejected(t *testing.T) {
+	proxy := &socksProxy{reply: 0x02} // connection not allowed by ruleset
+
+	_, err := dial(t, proxy.start(t), echoServer(t))
+	assertErrorContains(t, err, "socks5 connect rejected with code 2")
+}
+
+func TestDialerUnsupportedScheme(t *testing.T) {
+	_, err := Dialer(&url.URL{Scheme: "ftp", Host: "proxy:21"}, (&net.Dialer{}).DialContext)
+	assertErrorContains(t, err, "unsupported proxy scheme")
+}
@@ -12,3 +12,7 @@ func FromBase64(s string) ([]byte, error) {
 	return base64.StdEncoding.DecodeString(s)
 }
 
+// ToBase64URL encodes bytes to an unpadded base64url string
+func ToBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
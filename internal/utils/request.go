@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -23,6 +24,7 @@ type RequestOptions struct {
 	Body    []byte
 	Form    map[string]string
 	Client  *http.Client
+	Context context.Context
 }
 
 // RequestWithRetry performs an HTTP request with automatic retry logic
@@ -56,8 +58,13 @@ func retry(retryCount int, opts RequestOptions) ([]byte, error) {
 		}
 	}
 
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	// Create request
-	req, err := http.NewRequest(opts.Method, opts.URL, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, opts.Method, opts.URL, bodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -106,7 +113,8 @@ func retry(retryCount int, opts RequestOptions) ([]byte, error) {
 	return body, nil
 }
 
-// SimpleRequest performs a simple HTTP request without retry logic
+// SimpleRequest performs a simple HTTP request without retry logic.
+// A non-2xx response is returned as an error carrying the status and body.
 func SimpleRequest(opts RequestOptions) ([]byte, error) {
 	client := opts.Client
 	if client == nil {
@@ -133,8 +141,13 @@ func SimpleRequest(opts RequestOptions) ([]byte, error) {
 		}
 	}
 
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
 	// Create request
-	req, err := http.NewRequest(opts.Method, opts.URL, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, opts.Method, opts.URL, bodyReader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -157,6 +170,11 @@ func SimpleRequest(opts RequestOptions) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	// Check status code
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
 	return body, nil
 }
 
@@ -0,0 +1,124 @@
+package client
+
+import "sync"
+
+// WithManualAck makes the client report a message to the server as received only
+// after the application calls Ack on it. Until then a crash or reconnect leaves
+// the message unacknowledged and the server redelivers it.
+// Handlers registered with OnData or OnNotification must Ack too; an error or
+// panic from the handler Nacks the message.
+func WithManualAck() ClientOption {
+	return func(c *Client) {
+		c.manualAck = true
+	}
+}
+
+// acker acknowledges a single message in manual-ack mode
+type acker struct {
+	client       *Client
+	persistentID string
+	once         sync.Once
+}
+
+// Ack reports the message to the server as processed.
+// It is a no-op unless the client was created with WithManualAck.
+func (m *DataMessage) Ack() {
+	if m.acker == nil {
+		return
+	}
+	m.acker.once.Do(func() {
+		m.acker.client.ack(m.acker.persistentID)
+	})
+}
+
+// Nack gives up on the message without reporting it to the server, so it is
+// redelivered on a later connection.
+// It is a no-op unless the client was created with WithManualAck.
+func (m *DataMessage) Nack() {
+	if m.acker == nil {
+		return
+	}
+	m.acker.once.Do(func() {
+		m.acker.client.nack(m.acker.persistentID)
+	})
+}
+
+// attachAcker gives a delivered message its Ack and Nack methods in manual-ack mode
+func (c *Client) attachAcker(event Event, persistentID string) {
+	if !c.manualAck || persistentID == "" {
+		return
+	}
+
+	a := &acker{client: c, persistentID: persistentID}
+	switch msg := event.Data.(type) {
+	case *DataMessage:
+		msg.acker = a
+	case *Notification:
+		msg.acker = a
+	}
+}
+
+// markReceived records a new message. In manual-ack mode it stays pending
+// until acked; otherwise it is reported to the server at the next login.
+// A message without a persistent ID cannot be acked, so nothing is recorded.
+func (c *Client) markReceived(persistentID string) {
+	if persistentID == "" {
+		return
+	}
+	c.dedup.Add(persistentID)
+
+	if c.manualAck {
+		c.mu.Lock()
+		c.pendingAcks[persistentID] = struct{}{}
+		c.mu.Unlock()
+		return
+	}
+	c.rememberPersistentID(persistentID)
+}
+
+// isReceived reports whether a message was already received, and whether
+// it is still waiting for the application to ack it
+func (c *Client) isReceived(persistentID string) (received, pending bool) {
+	if persistentID == "" {
+		return false, false
+	}
+
+	c.mu.RLock()
+	_, pending = c.pendingAcks[persistentID]
+	c.mu.RUnlock()
+
+	return pending || c.dedup.Contains(persistentID), pending
+}
+
+// ackProcessed acknowledges a message once it has been handed to the application.
+// In manual-ack mode this is left to DataMessage.Ack.
+func (c *Client) ackProcessed(persistentID string) {
+	if persistentID == "" || c.manualAck {
+		return
+	}
+	c.sendSelectiveAck([]string{persistentID})
+}
+
+// ack reports a pending message to the server as received
+func (c *Client) ack(persistentID string) {
+	c.mu.Lock()
+	delete(c.pendingAcks, persistentID)
+	c.mu.Unlock()
+
+	c.rememberPersistentID(persistentID)
+	c.sendSelectiveAck([]string{persistentID})
+}
+
+// nack forgets a message that was not processed so the server redelivers it
+func (c *Client) nack(persistentID string) {
+	if persistentID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	delete(c.pendingAcks, persistentID)
+	c.mu.Unlock()
+
+	c.dedup.Remove(persistentID)
+	c.forgetPersistentIDs([]string{persistentID})
+}
@@ -0,0 +1,21 @@
+package client
+
+import "testing"
+
+func TestMarkReceivedIgnoresEmptyID(t *testing.T) {
+	for _, manual := range []bool{false, true} {
+		var opts []ClientOption
+		if manual {
+			opts = append(opts, WithManualAck())
+		}
+		c := NewClient("1234", "5678", nil, opts...)
+
+		c.markReceived("")
+		if len(c.pendingAcks) != 0 {
+			t.Errorf("manual=%v: pendingAcks = %v, want none", manual, c.pendingAcks)
+		}
+		if len(c.persistentIDs) != 0 {
+			t.Errorf("manual=%v: persistentIDs = %v, want none", manual, c.persistentIDs)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package client_test
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+)
+
+// nextMessage returns the next data message read from the client's events
+func nextMessage(t *testing.T, fcmClient *client.Client) *client.DataMessage {
+	t.Helper()
+	ctx := testContext(t)
+	for {
+		select {
+		case event := <-fcmClient.Events():
+			if msg, ok := event.DataMessage(); ok {
+				return msg
+			}
+		case <-ctx.Done():
+			t.Fatal("no data message received")
+			return nil
+		}
+	}
+}
+
+func TestManualAckSendsSelectiveAck(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil,
+		client.WithManualAck(),
+		client.WithReconnectPolicy(fixedDelay{}),
+	)
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	conn := waitConn(t, server)
+
+	sendData(t, conn, "a")
+	msg := nextMessage(t, fcmClient)
+	if acks := server.SelectiveAcks(); len(acks) != 0 {
+		t.Fatalf("SelectiveAcks before Ack = %v, want none", acks)
+	}
+
+	msg.Ack()
+	eventually(t, "the SelectiveAck", func() bool {
+		return len(server.SelectiveAcks()) > 0
+	})
+
+	// Acking twice, or nacking after the ack, changes nothing
+	msg.Ack()
+	msg.Nack()
+	time.Sleep(100 * time.Millisecond)
+	if got, want := server.SelectiveAcks(), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SelectiveAcks = %v, want %v", got, want)
+	}
+
+	// The ack is still reported at the next login and the redelivery is ignored
+	conn.Close()
+	conn = waitConn(t, server)
+	if got := conn.LoginRequest().GetReceivedPersistentId(); !slices.Contains(got, "a") {
+		t.Errorf("ReceivedPersistentId at re-login = %v, want it to contain a", got)
+	}
+	sendData(t, conn, "a")
+	sendData(t, conn, "b")
+	if msg := nextMessage(t, fcmClient); msg.PersistentID != "b" {
+		t.Errorf("received %q after the acked a, want b", msg.PersistentID)
+	}
+}
+
+func TestManualNackRedeliversMessage(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil,
+		client.WithManualAck(),
+		client.WithReconnectPolicy(fixedDelay{}),
+	)
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	conn := waitConn(t, server)
+
+	sendData(t, conn, "a")
+	nextMessage(t, fcmClient).Nack()
+
+	conn.Close()
+	conn = waitConn(t, server)
+	if got := conn.LoginRequest().GetReceivedPersistentId(); slices.Contains(got, "a") {
+		t.Errorf("ReceivedPersistentId at re-login = %v, want no a", got)
+	}
+	if acks := server.SelectiveAcks(); len(acks) != 0 {
+		t.Errorf("SelectiveAcks = %v, want none for a nacked message", acks)
+	}
+
+	// The server redelivers it and the client hands it over again
+	sendData(t, conn, "a")
+	if msg := nextMessage(t, fcmClient); msg.PersistentID != "a" {
+		t.Errorf("received %q, want the redelivered a", msg.PersistentID)
+	}
+}
@@ -0,0 +1,311 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// BackpressurePolicy decides what happens to an event when the event buffer is full
+type BackpressurePolicy int
+
+const (
+	// BackpressureDropNewest waits up to a second for room and then drops the new event
+	BackpressureDropNewest BackpressurePolicy = iota
+	// BackpressureBlock stops reading from FCM until the consumer makes room
+	BackpressureBlock
+	// BackpressureDropOldest drops the oldest buffered event to make room for the new one
+	BackpressureDropOldest
+	// BackpressureSpill writes events that do not fit in the buffer to a file on disk
+	BackpressureSpill
+)
+
+const (
+	// defaultEventBuffer is how many events are buffered for Events()
+	defaultEventBuffer = 100
+	// eventSendTimeout is how long an event may wait for room before it is dropped
+	eventSendTimeout = time.Second
+)
+
+// WithEventBuffer sets how many events are buffered while waiting to be read from Events()
+func WithEventBuffer(capacity int) ClientOption {
+	return func(c *Client) {
+		if capacity > 0 {
+			c.events.capacity = capacity
+		}
+	}
+}
+
+// WithBackpressure sets what happens when the event buffer is full.
+// A dropped data message is not acknowledged, so the server redelivers it.
+func WithBackpressure(policy BackpressurePolicy) ClientOption {
+	return func(c *Client) {
+		c.backpressure = policy
+	}
+}
+
+// WithSpillDir sets the directory used by BackpressureSpill, os.TempDir() by default
+func WithSpillDir(dir string) ClientOption {
+	return func(c *Client) {
+		c.events.spillDir = dir
+	}
+}
+
+// DroppedEvents returns how many events have been dropped because the event buffer was full
+func (c *Client) DroppedEvents() uint64 {
+	return c.dropped.Load()
+}
+
+// queuedEvent is an event waiting to be read from Events()
+type queuedEvent struct {
+	Event
+	// persistentID is acknowledged once the event has been handed over
+	persistentID string
+}
+
+// eventQueue buffers events between the listen loop and the Events channel
+type eventQueue struct {
+	mu       sync.Mutex
+	items    []queuedEvent
+	capacity int
+	spillDir string
+	spill    *spillFile
+	// ready is signalled when an event is queued, room when one leaves the queue
+	ready chan struct{}
+	room  chan struct{}
+}
+
+// newEventQueue creates an empty event queue
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		capacity: defaultEventBuffer,
+		ready:    make(chan struct{}, 1),
+		room:     make(chan struct{}, 1),
+	}
+}
+
+// push queues ev if there is room. It reports false when the buffer is full.
+func (q *eventQueue) push(ev queuedEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) >= q.capacity {
+		return false
+	}
+	q.items = append(q.items, ev)
+	signal(q.ready)
+	return true
+}
+
+// pushEvict queues ev, evicting and returning the oldest event if the buffer is full
+func (q *eventQueue) pushEvict(ev queuedEvent) (queuedEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var evicted queuedEvent
+	full := len(q.items) >= q.capacity
+	if full {
+		evicted = q.items[0]
+		q.items = q.items[1:]
+	}
+	q.items = append(q.items, ev)
+	signal(q.ready)
+	return evicted, full
+}
+
+// pushSpill queues ev, writing it to the spill file once the buffer is full.
+// Events keep going to disk until the spill file has drained so order is preserved.
+func (q *eventQueue) pushSpill(ev queuedEvent) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) < q.capacity && q.spill.len() == 0 {
+		q.items = append(q.items, ev)
+		signal(q.ready)
+		return nil
+	}
+
+	if q.spill == nil {
+		spill, err := openSpillFile(q.spillDir)
+		if err != nil {
+			return err
+		}
+		q.spill = spill
+	}
+	if err := q.spill.write(ev); err != nil {
+		return err
+	}
+	signal(q.ready)
+	return nil
+}
+
+// pop removes the oldest event, reading from the spill file once the buffer is empty.
+// A spilled event that cannot be read back is returned as an error event, together
+// with the persistent IDs of the events that were lost.
+func (q *eventQueue) pop() (queuedEvent, []string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) > 0 {
+		ev := q.items[0]
+		q.items[0] = queuedEvent{}
+		q.items = q.items[1:]
+		signal(q.room)
+		return ev, nil, true
+	}
+
+	if q.spill.len() > 0 {
+		ev, lost, err := q.spill.read()
+		if err != nil {
+			ev = queuedEvent{Event: Event{Type: EventError, Data: &ErrorInfo{Time: time.Now(), Err: err}}}
+		}
+		return ev, lost, true
+	}
+
+	return queuedEvent{}, nil, false
+}
+
+// close removes the spill file
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.spill != nil {
+		q.spill.close()
+		q.spill = nil
+	}
+}
+
+// signal wakes up a waiter on ch without blocking
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// queueEvent buffers an event for Events() according to the backpressure policy.
+// It reports false if the event was dropped.
+func (c *Client) queueEvent(ev queuedEvent) bool {
+	c.mu.RLock()
+	stopped := c.pumpDone
+	done := c.done
+	c.mu.RUnlock()
+
+	policy := c.backpressure
+	if !c.eventsRead.Load() {
+		// Nobody reads Events() (yet), keep what fits without waiting for room
+		policy = BackpressureDropOldest
+	}
+
+	switch policy {
+	case BackpressureDropOldest:
+		if evicted, ok := c.events.pushEvict(ev); ok {
+			c.dropEvent(evicted)
+		}
+		return true
+
+	case BackpressureSpill:
+		if err := c.events.pushSpill(ev); err != nil {
+			c.debugLog("Failed to spill event to disk: %v", err)
+			c.dropEvent(ev)
+			return false
+		}
+		return true
+	}
+
+	// BackpressureBlock waits for room as long as the client is running. Once it shuts
+	// down nobody may be reading anymore, and the pump only stops after this goroutine.
+	var timeout <-chan time.Time
+	if policy != BackpressureBlock {
+		timer := time.NewTimer(eventSendTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for !c.events.push(ev) {
+		if stopped == nil {
+			c.dropEvent(ev)
+			return false
+		}
+
+		select {
+		case <-c.events.room:
+		case <-stopped:
+			c.dropEvent(ev)
+			return false
+		case <-done:
+			c.dropEvent(ev)
+			return false
+		case <-timeout:
+			c.dropEvent(ev)
+			return false
+		}
+	}
+	return true
+}
+
+// dropEvent discards an event that could not be delivered.
+// A dropped message is forgotten so it is not reported to the server as received.
+func (c *Client) dropEvent(ev queuedEvent) {
+	c.dropped.Add(1)
+	c.debugLog("Warning: Event buffer full, dropping event: %s", ev.Type)
+	c.nack(ev.persistentID)
+}
+
+// dropLost forgets spilled events that could not be read back from disk
+func (c *Client) dropLost(persistentIDs []string) {
+	for _, id := range persistentIDs {
+		c.dropped.Add(1)
+		c.nack(id)
+	}
+	if len(persistentIDs) > 0 {
+		c.debugLog("Warning: Lost %d spilled events", len(persistentIDs))
+	}
+}
+
+// pumpEvents hands queued events to the Events channel until stop is closed.
+// After that it keeps delivering for a short grace period and drops whatever is left.
+func (c *Client) pumpEvents(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	defer c.events.close()
+	// Only now is it final which messages were dropped
+	defer c.saveDedupOnStop()
+
+	var flush <-chan time.Time
+	for {
+		ev, lost, ok := c.events.pop()
+		c.dropLost(lost)
+		if !ok {
+			if stop == nil {
+				return
+			}
+			select {
+			case <-c.events.ready:
+			case <-stop:
+				stop = nil
+				flush = time.After(eventSendTimeout)
+			}
+			continue
+		}
+		c.attachAcker(ev.Event, ev.persistentID)
+
+		for delivered := false; !delivered; {
+			select {
+			case c.eventChan <- ev.Event:
+				delivered = true
+				c.ackProcessed(ev.persistentID)
+			case <-stop:
+				stop = nil
+				flush = time.After(eventSendTimeout)
+			case <-flush:
+				// Nobody is reading anymore, drop the rest
+				c.dropEvent(ev)
+				for ev, lost, ok := c.events.pop(); ok; ev, lost, ok = c.events.pop() {
+					c.dropLost(lost)
+					c.dropEvent(ev)
+				}
+				return
+			}
+		}
+	}
+}
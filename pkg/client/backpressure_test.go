@@ -0,0 +1,138 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+)
+
+func TestBlockingBackpressureStopsWithoutReader(t *testing.T) {
+	store := client.NewMemoryPersistentIDStore()
+	server, fcmClient := newTestClient(t, nil,
+		client.WithEventBuffer(4),
+		client.WithBackpressure(client.BackpressureBlock),
+		client.WithPersistentIDStore(store),
+	)
+	// Subscribe without ever reading, so the policy applies
+	fcmClient.Events()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := runInBackground(ctx, fcmClient)
+
+	conn := waitConn(t, server)
+	for _, id := range ids("msg", 5) {
+		sendData(t, conn, id)
+	}
+
+	// Let the listen loop fill the buffer and block on it
+	eventually(t, "a stored message", func() bool {
+		stored, _ := store.Load()
+		return len(stored) > 0
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	waitResult(t, result)
+
+	// Nothing was read, so nothing may be reported as received
+	if stored, _ := store.Load(); len(stored) != 0 {
+		t.Errorf("stored persistent IDs = %v, want none", stored)
+	}
+}
+
+func TestBlockingBackpressureCloseFromReader(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil,
+		client.WithEventBuffer(1),
+		client.WithBackpressure(client.BackpressureBlock),
+	)
+
+	result := runInBackground(context.Background(), fcmClient)
+
+	conn := waitConn(t, server)
+	for _, id := range ids("msg", 5) {
+		sendData(t, conn, id)
+	}
+
+	// Stop reading and close from the reader once the first message arrives
+	closed := make(chan error, 1)
+	go func() {
+		for event := range fcmClient.Events() {
+			if _, ok := event.DataMessage(); ok {
+				closed <- fcmClient.Close()
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Close did not return")
+	}
+	waitResult(t, result)
+}
+
+func TestHandlersWithoutEventsReaderNeverStall(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy client.BackpressurePolicy
+	}{
+		{"DropNewest", client.BackpressureDropNewest},
+		{"Block", client.BackpressureBlock},
+		{"DropOldest", client.BackpressureDropOldest},
+		{"Spill", client.BackpressureSpill},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server, fcmClient := newTestClient(t, nil,
+				client.WithEventBuffer(2),
+				client.WithBackpressure(tt.policy),
+				client.WithSpillDir(t.TempDir()),
+				client.WithReconnectPolicy(fixedDelay{}),
+			)
+			handled := make(chan string, 10)
+			fcmClient.OnData(func(msg *client.DataMessage) error {
+				handled <- msg.PersistentID
+				return nil
+			})
+			waitHandled := func(want string) {
+				t.Helper()
+				select {
+				case id := <-handled:
+					if id != want {
+						t.Fatalf("handled %q, want %q", id, want)
+					}
+				case <-time.After(testTimeout):
+					t.Fatalf("%s was not handled", want)
+				}
+			}
+
+			result := runInBackground(testContext(t), fcmClient)
+
+			// State changes, heartbeats and the connect event have no handler
+			conn := waitConn(t, server)
+			for i := 0; i < 5; i++ {
+				if err := conn.SendHeartbeatPing(); err != nil {
+					t.Fatalf("SendHeartbeatPing: %v", err)
+				}
+			}
+			for _, id := range ids("msg", 3) {
+				sendData(t, conn, id)
+				waitHandled(id)
+			}
+
+			// Reconnecting adds more unhandled events
+			conn.Close()
+			sendData(t, waitConn(t, server), "after")
+			waitHandled("after")
+
+			fcmClient.Close()
+			waitResult(t, result)
+		})
+	}
+}
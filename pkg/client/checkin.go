@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/internal/gcm"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// defaultCheckinInterval is how often a connected client checks in again
+const defaultCheckinInterval = 24 * time.Hour
+
+// WithCheckinInterval sets how often the client repeats the GCM check-in while it
+// runs, once a day by default. Zero or less disables periodic check-ins.
+func WithCheckinInterval(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.checkinInterval = interval
+	}
+}
+
+// CheckinResponse returns a copy of the response to the most recent successful
+// check-in, or nil if the client has not checked in yet
+func (c *Client) CheckinResponse() *pb.AndroidCheckinResponse {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.checkinResponse == nil {
+		return nil
+	}
+	return proto.Clone(c.checkinResponse).(*pb.AndroidCheckinResponse)
+}
+
+// checkIn performs a GCM check-in and keeps the response.
+// A failure is also emitted as EventCheckinFailed.
+func (c *Client) checkIn(ctx context.Context) error {
+	c.debugLog("Performing GCM check-in...")
+	resp, err := gcm.CheckInContext(ctx, c.androidID, c.securityToken, gcm.Options{
+		Client:     c.httpClient,
+		CheckinURL: c.checkinURL,
+	})
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrCheckinFailed, err)
+		c.debugLog("%v", err)
+		c.sendEvent(Event{Type: EventCheckinFailed, Data: &ErrorInfo{Time: time.Now(), Err: err}})
+		return err
+	}
+
+	c.mu.Lock()
+	c.checkinResponse = resp
+	c.mu.Unlock()
+	c.debugLog("GCM check-in successful")
+
+	return nil
+}
+
+// checkinLoop repeats the GCM check-in every checkinInterval until ctx is done
+func (c *Client) checkinLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.checkinInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Failures are reported as events, the next tick tries again
+			c.checkIn(ctx)
+		}
+	}
+}
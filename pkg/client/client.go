@@ -2,17 +2,25 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdh"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/palbooo/push-receiver-go/internal/constants"
-	"github.com/palbooo/push-receiver-go/internal/gcm"
+	"github.com/palbooo/push-receiver-go/internal/ece"
 	"github.com/palbooo/push-receiver-go/internal/parser"
+	"github.com/palbooo/push-receiver-go/internal/proxy"
+	"github.com/palbooo/push-receiver-go/pkg/register"
 	pb "github.com/palbooo/push-receiver-go/proto"
 	"google.golang.org/protobuf/proto"
 )
@@ -35,9 +43,32 @@ const (
 	EventHeartbeatPing EventType = "HEARTBEAT_PING"
 	// EventHeartbeatAck is emitted when we send a heartbeat ack
 	EventHeartbeatAck EventType = "HEARTBEAT_ACK"
+	// EventReconnecting is emitted before each reconnect attempt
+	EventReconnecting EventType = "RECONNECTING"
+	// EventCheckinFailed is emitted when a GCM check-in fails
+	EventCheckinFailed EventType = "CHECKIN_FAILED"
+	// EventStateChange is emitted whenever the connection state changes
+	EventStateChange EventType = "STATE_CHANGE"
 )
 
-// Event represents an event from the FCM client
+// ErrClientClosed is returned when connecting a client that has been closed
+var ErrClientClosed = errors.New("client is closed")
+
+// ErrAlreadyConnected is returned when connecting a client that is already running
+var ErrAlreadyConnected = errors.New("client is already connected")
+
+const (
+	// defaultHeartbeatInterval is used until the server sends a heartbeat config
+	// (FCM expects a heartbeat within ~5 min)
+	defaultHeartbeatInterval = 4 * time.Minute
+	// heartbeatGrace is added to the heartbeat interval to derive the read timeout
+	heartbeatGrace = time.Minute
+)
+
+// Event represents an event from the FCM client.
+// Data holds a *ConnectInfo, *DisconnectInfo, *ReconnectInfo, *StateChange,
+// *DataMessage, *Notification or *ErrorInfo (for EventError and EventCheckinFailed)
+// depending on Type; use the typed accessors to read it.
 type Event struct {
 	Type EventType
 	Data interface{}
@@ -48,16 +79,56 @@ type Client struct {
 	androidID       string
 	securityToken   string
 	persistentIDs   []string
-	conn            *tls.Conn
+	loginIDs        []string
+	idStore         PersistentIDStore
+	dedup           *DedupCache
+	credentialStore register.CredentialStore
+	pendingAcks     map[string]struct{}
+	manualAck       bool
+	conn            net.Conn
 	parser          *parser.Parser
+	stream          *streamState
+	writeMu         sync.Mutex
+	streamAckEvery  int
 	eventChan       chan Event
+	events          *eventQueue
+	backpressure    BackpressurePolicy
+	dropped         atomic.Uint64
+	eventsRead      atomic.Bool
+	pumpDone        chan struct{}
 	retryCount      int
-	maxRetryTimeout int
+	lostAt          time.Time
+	reconnectPolicy ReconnectPolicy
 	mu              sync.RWMutex
 	closed          bool
-	closeChan       chan struct{}
+	state           *stateNode
+	cancel          context.CancelFunc
+	runErr          error
+	done            <-chan struct{}
+	wg              sync.WaitGroup
+	handlers        handlers
+	handlerWorkers  int
+	handlerJobs     chan handlerJob
+	iqHandlers      map[int32]IQHandler
 	debugMode       bool
 	readTimeout     time.Duration
+	readTimeoutSet  bool
+	// heartbeatInterval is the negotiated interval, heartbeatOverride the user's choice
+	heartbeatInterval time.Duration
+	heartbeatOverride time.Duration
+	heartbeatReset    chan struct{}
+	heartbeatAcked    chan struct{}
+	adaptive          *adaptiveHeartbeat
+	webPushKeys       *ece.Keys
+	dial              DialFunc
+	mcsHost           string
+	mcsPort           string
+	tlsConfig         *tls.Config
+	proxyURL          *url.URL
+	httpClient        *http.Client
+	checkinURL        string
+	checkinInterval   time.Duration
+	checkinResponse   *pb.AndroidCheckinResponse
 }
 
 // ClientOption is a function that configures the client
@@ -74,6 +145,33 @@ func WithDebugMode(enabled bool) ClientOption {
 func WithReadTimeout(timeout time.Duration) ClientOption {
 	return func(c *Client) {
 		c.readTimeout = timeout
+		c.readTimeoutSet = true
+	}
+}
+
+// WithHeartbeatInterval sets the heartbeat interval, overriding whatever the server negotiates.
+// Unless WithReadTimeout is also given, the read timeout is derived from it.
+func WithHeartbeatInterval(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.heartbeatOverride = interval
+	}
+}
+
+// WithStreamAckInterval sets how many messages may be received before a StreamAck is sent
+func WithStreamAckInterval(messages int) ClientOption {
+	return func(c *Client) {
+		c.streamAckEvery = messages
+	}
+}
+
+// WithWebPushKeys sets the ECDH P-256 private key and 16-byte auth secret used to
+// decrypt Web Push notifications (aes128gcm and the legacy aesgcm encoding)
+func WithWebPushKeys(privateKey *ecdh.PrivateKey, authSecret []byte) ClientOption {
+	return func(c *Client) {
+		c.webPushKeys = &ece.Keys{
+			PrivateKey: privateKey,
+			AuthSecret: authSecret,
+		}
 	}
 }
 
@@ -87,117 +185,254 @@ func NewClient(androidID, securityToken string, persistentIDs []string, opts ...
 		androidID:       androidID,
 		securityToken:   securityToken,
 		persistentIDs:   persistentIDs,
-		eventChan:       make(chan Event, 100),
-		maxRetryTimeout: 15,
-		closeChan:       make(chan struct{}),
+		pendingAcks:     make(map[string]struct{}),
+		idStore:         NewMemoryPersistentIDStore(),
+		dedup:           NewDedupCache(DefaultDedupSize, DefaultDedupTTL),
+		eventChan:       make(chan Event),
+		events:          newEventQueue(),
+		reconnectPolicy: DefaultReconnectPolicy(),
+		streamAckEvery:  constants.UnackedMessagesBeforeStreamAck,
 		debugMode:       false,
 		readTimeout:     5 * time.Minute, // Default: 5 minutes (FCM sends heartbeat every ~4 min)
+		heartbeatReset:  make(chan struct{}, 1),
+		heartbeatAcked:  make(chan struct{}, 1),
+		handlerWorkers:  1,
+		handlerJobs:     make(chan handlerJob),
+		dial:            defaultDial,
+		mcsHost:         constants.MCSHost,
+		mcsPort:         constants.MCSPort,
+		checkinInterval: defaultCheckinInterval,
+		state:           newStateNode(StateIdle),
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
 
+	// Derived after the options so WithHTTPClient wins regardless of order
+	if c.httpClient == nil && c.proxyURL != nil {
+		c.httpClient = proxy.HTTPClient(c.proxyURL)
+	}
+
+	if c.adaptive != nil {
+		c.setHeartbeatInterval(c.adaptive.current())
+	} else {
+		c.setHeartbeatInterval(defaultHeartbeatInterval)
+	}
+
 	return c
 }
 
-// Events returns a channel that receives events from the client
+// Events returns a channel that receives events from the client.
+// Events are buffered as configured with WithEventBuffer and WithBackpressure,
+// and a data message is acknowledged only once it has been read from the channel.
+// Until Events is first called the backpressure policy does not apply: the buffer
+// keeps the most recent events without ever holding up the connection, so clients
+// that only register handlers never stall on events nobody reads.
 func (c *Client) Events() <-chan Event {
+	c.eventsRead.Store(true)
 	return c.eventChan
 }
 
 // Connect establishes a connection to FCM and starts listening for messages
 func (c *Client) Connect() error {
-	c.debugLog("Starting connection to FCM...")
+	return c.ConnectContext(context.Background())
+}
 
-	// Perform GCM check-in
-	c.debugLog("Performing GCM check-in...")
-	if _, err := gcm.CheckIn(c.androidID, c.securityToken); err != nil {
-		return fmt.Errorf("check-in failed: %w", err)
+// ConnectContext establishes a connection to FCM and starts listening for messages.
+// Cancelling ctx aborts the check-in, the dial, any reconnect backoff and the
+// listen loop, exactly as if Close had been called.
+func (c *Client) ConnectContext(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClientClosed
 	}
-	c.debugLog("GCM check-in successful")
+	if c.cancel != nil {
+		c.mu.Unlock()
+		return ErrAlreadyConnected
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	c.cancel = cancel
+	c.done = runCtx.Done()
+	c.mu.Unlock()
 
-	// Connect to MCS server
-	if err := c.connect(); err != nil {
+	c.debugLog("Starting connection to FCM...")
+
+	conn, err := c.start(runCtx)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Cancelled while connecting, which closes the client like Close
+			c.Close()
+			return ctx.Err()
+		}
+		if runCtx.Err() != nil {
+			// Close was called while connecting
+			return ErrClientClosed
+		}
+
+		cancel()
+		c.mu.Lock()
+		c.cancel = nil
+		c.mu.Unlock()
+		c.setState(StateIdle)
 		return err
 	}
 
-	// Start listening for messages
-	go c.listen()
+	// Start the workers that run data and notification handlers
+	c.startHandlerWorkers(runCtx)
+
+	// Start listening for messages and reconnecting when the connection drops
+	c.wg.Add(2)
+	go c.run(runCtx, conn)
 
 	// Start sending heartbeat pings to keep connection alive
-	go c.heartbeatLoop()
+	go c.heartbeatLoop(runCtx)
+
+	// Re-validate the credentials with a check-in now and then
+	if c.checkinInterval > 0 {
+		c.wg.Add(1)
+		go c.checkinLoop(runCtx)
+	}
+
+	// Hand buffered events to Events() until everything else has stopped
+	stopPump := make(chan struct{})
+	pumpDone := make(chan struct{})
+	c.mu.Lock()
+	c.pumpDone = pumpDone
+	c.mu.Unlock()
+	go c.pumpEvents(stopPump, pumpDone)
+	go func() {
+		c.wg.Wait()
+		close(stopPump)
+	}()
 
 	return nil
 }
 
+// Run connects to FCM and blocks until ctx is cancelled or Close is called.
+// It returns only after every goroutine started by the client has exited,
+// which makes it suitable for use with errgroup and similar supervisors.
+// When the reconnect policy gives up Run returns the last connection error, which
+// matches ErrAuthRejected if the server rejected the credentials.
+func (c *Client) Run(ctx context.Context) error {
+	if err := c.ConnectContext(ctx); err != nil {
+		return err
+	}
+
+	c.wg.Wait()
+
+	c.mu.RLock()
+	pumpDone := c.pumpDone
+	runErr := c.runErr
+	c.mu.RUnlock()
+	<-pumpDone
+
+	if runErr != nil {
+		return runErr
+	}
+	return ctx.Err()
+}
+
 // Close closes the connection to FCM
 func (c *Client) Close() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if c.closed {
+		c.mu.Unlock()
 		return nil
 	}
 
 	c.debugLog("Closing FCM connection...")
 	c.closed = true
-	close(c.closeChan)
+	change := c.setStateLocked(StateClosed)
+	if c.cancel != nil {
+		c.cancel()
+	}
 
+	var err error
 	if c.conn != nil {
-		return c.conn.Close()
+		err = c.conn.Close()
 	}
+	c.mu.Unlock()
 
-	return nil
-}
+	if change != nil {
+		c.sendEvent(Event{Type: EventStateChange, Data: change})
+	}
 
-// connect establishes a TLS connection and sends the login request
-func (c *Client) connect() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	return err
+}
 
-	// Create TLS connection with timeout
-	config := &tls.Config{
-		ServerName: constants.MCSHost,
+// start performs the GCM check-in and opens the first MCS connection
+func (c *Client) start(ctx context.Context) (net.Conn, error) {
+	if err := c.loadPersistentIDs(); err != nil {
+		return nil, err
 	}
 
-	addr := constants.MCSHost + ":" + constants.MCSPort
-	c.debugLog("Connecting to %s...", addr)
-
-	// Use dialer with timeout and keep-alive
-	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second, // TCP keep-alive to prevent NAT/firewall timeouts
+	// Perform GCM check-in
+	c.setState(StateCheckingIn)
+	if err := c.checkIn(ctx); err != nil {
+		return nil, err
 	}
 
-	netConn, err := tls.DialWithDialer(dialer, "tcp", addr, config)
+	// Connect to MCS server
+	return c.connect(ctx)
+}
+
+// connect establishes a TLS connection and sends the login request
+func (c *Client) connect(ctx context.Context) (net.Conn, error) {
+	c.setState(StateDialing)
+	conn, err := c.dialMCS(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to connect to MCS: %w", err)
+		return nil, fmt.Errorf("failed to connect to MCS: %w", err)
 	}
-
-	c.conn = netConn
-	c.parser = parser.NewParser(netConn)
 	c.debugLog("TLS connection established")
 
 	// Send login request
 	loginBuf, err := c.buildLoginRequest()
 	if err != nil {
-		c.conn.Close()
-		return fmt.Errorf("failed to build login request: %w", err)
+		conn.Close()
+		return nil, fmt.Errorf("failed to build login request: %w", err)
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return nil, ErrClientClosed
 	}
+	c.conn = conn
+	c.parser = parser.NewParser(conn)
+	c.stream = newStreamState()
+	c.mu.Unlock()
 
+	c.setState(StateLoggingIn)
 	c.debugLog("Sending login request...")
-	if _, err := c.conn.Write(loginBuf); err != nil {
-		c.conn.Close()
-		return fmt.Errorf("failed to send login request: %w", err)
+	// A peer that stops reading must not block past cancellation
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	_, err = conn.Write(loginBuf)
+	stop()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to send login request: %w", err)
 	}
 	c.debugLog("Login request sent")
 
-	return nil
+	return conn, nil
 }
 
 // buildLoginRequest creates the login request buffer
 func (c *Client) buildLoginRequest() ([]byte, error) {
+	// Remember what we report so it can be pruned once the server confirms the login
+	c.mu.Lock()
+	persistentIDs := append([]string(nil), c.persistentIDs...)
+	c.loginIDs = persistentIDs
+	c.mu.Unlock()
+
 	// Convert androidID to hex
 	androidIDInt, err := strconv.ParseUint(c.androidID, 10, 64)
 	if err != nil {
@@ -206,9 +441,15 @@ func (c *Client) buildLoginRequest() ([]byte, error) {
 	hexAndroidID := strconv.FormatUint(androidIDInt, 16)
 
 	// Build login request
+	var heartbeatStat *pb.HeartbeatStat
+	if c.adaptive != nil {
+		heartbeatStat = c.adaptive.pendingStat()
+	}
+
 	authService := pb.LoginRequest_ANDROID_ID
 	loginReq := &pb.LoginRequest{
-		AdaptiveHeartbeat: proto.Bool(false),
+		AdaptiveHeartbeat: proto.Bool(c.adaptive != nil),
+		HeartbeatStat:     heartbeatStat,
 		AuthService:       &authService,
 		AuthToken:         proto.String(c.securityToken),
 		Id:                proto.String("chrome-63.0.3234.0"),
@@ -223,9 +464,13 @@ func (c *Client) buildLoginRequest() ([]byte, error) {
 				Name:  proto.String("new_vc"),
 				Value: proto.String("1"),
 			},
+			{
+				Name:  proto.String("hbping"),
+				Value: proto.String(strconv.FormatInt(c.getHeartbeatInterval().Milliseconds(), 10)),
+			},
 		},
 		ClientEvent:          []*pb.ClientEvent{},
-		ReceivedPersistentId: c.persistentIDs,
+		ReceivedPersistentId: persistentIDs,
 	}
 
 	// Marshal the protobuf
@@ -244,61 +489,121 @@ func (c *Client) buildLoginRequest() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// listen continuously reads messages from the connection
-func (c *Client) listen() {
-	defer func() {
-		c.debugLog("Listen loop exiting, sending disconnect event...")
-		c.sendEvent(Event{Type: EventDisconnect})
-		c.retry()
-	}()
+// run listens on conn and reconnects whenever the connection drops, until ctx is done
+func (c *Client) run(ctx context.Context, conn net.Conn) {
+	defer c.wg.Done()
+	// A cancelled context shuts the client down just like Close
+	defer c.Close()
 
-	messageCount := 0
 	for {
-		select {
-		case <-c.closeChan:
-			c.debugLog("Close signal received, exiting listen loop")
-			return
-		default:
+		err := c.listen(ctx, conn)
+
+		// The connection is gone, stop reporting it as connected right away
+		if ctx.Err() == nil {
+			c.setState(StateBackoff)
 		}
 
-		// Set read deadline to detect dead connections
-		c.mu.RLock()
-		conn := c.conn
-		c.mu.RUnlock()
+		c.debugLog("Listen loop exited, sending disconnect event...")
+		c.sendEvent(Event{Type: EventDisconnect, Data: &DisconnectInfo{Time: time.Now(), Err: err}})
 
-		if conn != nil {
-			conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+		var ok bool
+		if conn, ok = c.reconnect(ctx, err); !ok {
+			return
 		}
+	}
+}
 
-		msg, err := c.parser.ReadMessage()
+// listen continuously reads messages from conn until it fails or ctx is done.
+// It returns the error that ended the connection, or nil when ctx is done.
+func (c *Client) listen(ctx context.Context, conn net.Conn) error {
+	// Unblock the pending read as soon as the context is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+	defer conn.Close()
+
+	c.mu.RLock()
+	p := c.parser
+	stream := c.stream
+	c.mu.RUnlock()
+
+	messageCount := 0
+	for {
+		// Set read deadline to detect dead connections
+		readTimeout := c.getReadTimeout()
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		msg, err := p.ReadMessage()
 		if err != nil {
+			if ctx.Err() != nil {
+				c.debugLog("Context done, exiting listen loop")
+				return nil
+			}
+
 			// Check if it's a timeout error
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				c.debugLog("Read timeout after %v - connection may be dead", c.readTimeout)
-				c.sendEvent(Event{Type: EventError, Data: fmt.Errorf("read timeout: %w", err)})
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.debugLog("Read timeout after %v - connection may be dead", readTimeout)
+				err = fmt.Errorf("read timeout: %w", err)
 			} else {
 				c.debugLog("Read error: %v", err)
-				c.sendEvent(Event{Type: EventError, Data: err})
 			}
-			return
+			c.sendError(err)
+			return err
 		}
 
 		messageCount++
 		c.debugLog("Received message #%d, tag: %d", messageCount, msg.Tag)
-		c.handleMessage(msg)
+
+		if confirmed := stream.received(msg.Object); len(confirmed) > 0 {
+			c.debugLog("Server confirmed %d acknowledged persistent IDs", len(confirmed))
+			c.forgetPersistentIDs(confirmed)
+		}
+
+		if err := c.handleMessage(msg); err != nil {
+			c.sendError(err)
+			return err
+		}
+
+		if c.streamAckEvery > 0 && stream.unacked() >= c.streamAckEvery {
+			c.sendStreamAck()
+		}
 	}
 }
 
-// handleMessage processes a received message
-func (c *Client) handleMessage(msg *parser.Message) {
+// handleMessage processes a received message.
+// It returns an error when the server has ended the session.
+func (c *Client) handleMessage(msg *parser.Message) error {
 	switch msg.Tag {
 	case constants.LoginResponseTag:
+		info := &ConnectInfo{Time: time.Now()}
+		if resp, ok := msg.Object.(*pb.LoginResponse); ok {
+			if loginErr := resp.GetError(); loginErr != nil && loginErr.GetCode() != 0 {
+				c.debugLog("Received LoginResponse with error code %d: %s", loginErr.GetCode(), loginErr.GetMessage())
+				return &LoginError{
+					Code:    loginErr.GetCode(),
+					Message: loginErr.GetMessage(),
+					Type:    loginErr.GetType(),
+				}
+			}
+			c.applyHeartbeatConfig(resp)
+			if resp.ServerTimestamp != nil {
+				info.ServerTime = time.UnixMilli(resp.GetServerTimestamp())
+			}
+		}
 		c.debugLog("Received LoginResponse - connection authenticated")
+		info.HeartbeatInterval = c.getHeartbeatInterval()
 		c.mu.Lock()
-		c.persistentIDs = []string{}
+		loginIDs := c.loginIDs
+		c.loginIDs = nil
 		c.retryCount = 0
 		c.mu.Unlock()
-		c.sendEvent(Event{Type: EventConnect})
+		c.reconnectPolicy.Reset()
+		c.setState(StateConnected)
+		// The server has everything we reported at login
+		c.forgetPersistentIDs(loginIDs)
+		c.sendEvent(Event{Type: EventConnect, Data: info})
 
 	case constants.DataMessageStanzaTag:
 		c.debugLog("Received DataMessageStanza")
@@ -312,18 +617,35 @@ func (c *Client) handleMessage(msg *parser.Message) {
 		c.sendHeartbeatAck()
 
 	case constants.HeartbeatAckTag:
-		c.debugLog("Received HeartbeatAck from server (unexpected)")
+		c.debugLog("Received HeartbeatAck from server")
+		select {
+		case c.heartbeatAcked <- struct{}{}:
+		default:
+		}
 
 	case constants.CloseTag:
 		c.debugLog("Received Close message from server")
-		c.sendEvent(Event{Type: EventError, Data: fmt.Errorf("server sent close message")})
+		c.sendError(fmt.Errorf("server sent close message"))
 
 	case constants.IqStanzaTag:
-		c.debugLog("Received IqStanza (ignoring)")
+		if iq, ok := msg.Object.(*pb.IqStanza); ok {
+			c.handleIq(iq)
+		}
+
+	case constants.StreamErrorStanzaTag:
+		streamErr := &StreamError{}
+		if stanza, ok := msg.Object.(*pb.StreamErrorStanza); ok {
+			streamErr.Type = stanza.GetType()
+			streamErr.Text = stanza.GetText()
+		}
+		c.debugLog("Received StreamErrorStanza: %v", streamErr)
+		return streamErr
 
 	default:
 		c.debugLog("Received unknown message tag: %d", msg.Tag)
 	}
+
+	return nil
 }
 
 // handleDataMessage processes a data message stanza
@@ -332,169 +654,446 @@ func (c *Client) handleDataMessage(msg *pb.DataMessageStanza) {
 	c.debugLog("Processing DataMessage with persistentId: %s", persistentID)
 
 	// Check if we've already received this message
-	c.mu.RLock()
+	if received, pending := c.isReceived(persistentID); received {
+		c.debugLog("Duplicate message, ignoring (persistentId: %s)", persistentID)
+		if !pending {
+			// The server redelivered it, so our earlier ack never arrived
+			c.sendSelectiveAck([]string{persistentID})
+		}
+		return
+	}
+
+	c.markReceived(persistentID)
+
+	dataMsg := newDataMessage(msg)
+	event := Event{Type: EventDataReceived, Data: dataMsg}
+
+	// Check if message is an encrypted Web Push notification
+	if encoding := contentEncoding(dataMsg.AppData); encoding != "" {
+		notification := &Notification{
+			DataMessage: *dataMsg,
+			Encoding:    encoding,
+		}
+
+		if c.webPushKeys != nil {
+			plaintext, err := ece.Decrypt(*c.webPushKeys, ece.Params{
+				Encoding:   encoding,
+				Payload:    dataMsg.RawData,
+				CryptoKey:  dataMsg.AppData["crypto-key"],
+				Encryption: dataMsg.AppData["encryption"],
+			})
+			if err != nil {
+				c.debugLog("Failed to decrypt notification: %v", err)
+				c.sendError(fmt.Errorf("failed to decrypt notification %s: %w", persistentID, err))
+			} else {
+				notification.Decrypted = plaintext
+			}
+		}
+
+		event = Event{Type: EventNotificationReceived, Data: notification}
+	}
+	c.attachAcker(event, persistentID)
+
+	// A registered handler acknowledges the message once it has processed it
+	if c.dispatchMessage(event, persistentID) {
+		c.debugLog("Dispatched %s to handler", event.Type)
+	} else {
+		// The event pump acknowledges the message once it has been read
+		c.debugLog("Emitting %s", event.Type)
+		c.queueEvent(queuedEvent{Event: event, persistentID: persistentID})
+	}
+
+	if msg.GetImmediateAck() {
+		c.debugLog("Server requested immediate ack")
+		c.sendStreamAck()
+	}
+}
+
+// contentEncoding returns the Web Push content encoding of a message, or "" if it is not encrypted
+func contentEncoding(appData map[string]string) string {
+	for _, key := range []string{"content-encoding", "encoding"} {
+		if encoding := appData[key]; encoding == ece.AES128GCM || encoding == ece.AESGCM {
+			return encoding
+		}
+	}
+	if _, hasCryptoKey := appData["crypto-key"]; hasCryptoKey {
+		return ece.AESGCM
+	}
+	return ""
+}
+
+// loadPersistentIDs merges the stored persistent IDs into the ones to report at login
+func (c *Client) loadPersistentIDs() error {
+	stored, err := c.idStore.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load persistent IDs: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	known := make(map[string]struct{}, len(c.persistentIDs))
 	for _, id := range c.persistentIDs {
-		if id == persistentID {
-			c.mu.RUnlock()
-			c.debugLog("Duplicate message, ignoring (persistentId: %s)", persistentID)
-			return
+		known[id] = struct{}{}
+	}
+	for _, id := range stored {
+		if _, ok := known[id]; !ok {
+			c.persistentIDs = append(c.persistentIDs, id)
 		}
 	}
-	c.mu.RUnlock()
 
-	// Add to persistent IDs
+	// Anything we still report was received, so a redelivery is a duplicate
+	for _, id := range c.persistentIDs {
+		c.dedup.Add(id)
+	}
+	return nil
+}
+
+// rememberPersistentID records a received message so it is reported at the next login
+func (c *Client) rememberPersistentID(persistentID string) {
 	c.mu.Lock()
 	c.persistentIDs = append(c.persistentIDs, persistentID)
 	c.mu.Unlock()
 
-	// Convert app data to map
-	appData := make(map[string]string)
-	for _, data := range msg.AppData {
-		appData[data.GetKey()] = data.GetValue()
+	if err := c.idStore.Add(persistentID); err != nil {
+		c.debugLog("Failed to store persistent ID %s: %v", persistentID, err)
+		c.sendError(fmt.Errorf("failed to store persistent ID: %w", err))
 	}
+}
 
-	// Check if message contains crypto-key (encrypted notification)
-	if _, hasCryptoKey := appData["crypto-key"]; hasCryptoKey {
-		c.debugLog("Emitting EventNotificationReceived (encrypted)")
-		c.sendEvent(Event{
-			Type: EventNotificationReceived,
-			Data: map[string]interface{}{
-				"persistentId": persistentID,
-				"from":         msg.GetFrom(),
-				"category":     msg.GetCategory(),
-				"appData":      appData,
-				"rawData":      msg.GetRawData(),
-			},
-		})
-	} else {
-		c.debugLog("Emitting EventDataReceived")
-		c.sendEvent(Event{
-			Type: EventDataReceived,
-			Data: map[string]interface{}{
-				"persistentId": persistentID,
-				"from":         msg.GetFrom(),
-				"category":     msg.GetCategory(),
-				"appData":      appData,
-				"rawData":      msg.GetRawData(),
-			},
-		})
+// forgetPersistentIDs drops persistent IDs the server no longer needs to be told about
+func (c *Client) forgetPersistentIDs(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	drop := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		drop[id] = struct{}{}
+	}
+
+	c.mu.Lock()
+	kept := c.persistentIDs[:0]
+	for _, id := range c.persistentIDs {
+		if _, ok := drop[id]; !ok {
+			kept = append(kept, id)
+		}
+	}
+	c.persistentIDs = kept
+	c.mu.Unlock()
+
+	if err := c.idStore.Remove(ids...); err != nil {
+		c.debugLog("Failed to remove persistent IDs from store: %v", err)
+		c.sendError(fmt.Errorf("failed to remove persistent IDs from store: %w", err))
 	}
 }
 
-// sendHeartbeatAck sends a heartbeat acknowledgment to the server
-func (c *Client) sendHeartbeatAck() {
-	ack := &pb.HeartbeatAck{}
-	data, err := proto.Marshal(ack)
+// writeMessage frames and sends a stanza, stamping it with the stream accounting fields.
+// It returns the stream ID assigned to the message.
+func (c *Client) writeMessage(tag uint8, msg proto.Message) (int32, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.RLock()
+	conn := c.conn
+	stream := c.stream
+	c.mu.RUnlock()
+
+	if conn == nil || stream == nil {
+		return 0, fmt.Errorf("connection is nil")
+	}
+
+	streamID, lastReceived := stream.sent()
+	setLastStreamIDReceived(msg, lastReceived)
+
+	data, err := proto.Marshal(msg)
 	if err != nil {
-		c.debugLog("Failed to marshal HeartbeatAck: %v", err)
-		return
+		return 0, fmt.Errorf("failed to marshal message: %w", err)
 	}
 
 	var buf bytes.Buffer
-	buf.WriteByte(constants.HeartbeatAckTag)
+	buf.WriteByte(tag)
 	buf.Write(parser.EncodeVarint(uint32(len(data))))
 	buf.Write(data)
 
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return streamID, nil
+}
+
+// sendStreamAck acknowledges every message received so far on the stream
+func (c *Client) sendStreamAck() {
+	iq, err := buildStreamAck()
+	if err != nil {
+		c.debugLog("Failed to build StreamAck: %v", err)
+		return
+	}
+
+	if _, err := c.writeMessage(constants.IqStanzaTag, iq); err != nil {
+		c.debugLog("Failed to send StreamAck: %v", err)
+		return
+	}
+	c.debugLog("Sent StreamAck")
+}
+
+// sendSelectiveAck acknowledges processed messages by persistent ID
+func (c *Client) sendSelectiveAck(ids []string) {
+	iq, err := buildSelectiveAck(ids)
+	if err != nil {
+		c.debugLog("Failed to build SelectiveAck: %v", err)
+		return
+	}
+
 	c.mu.RLock()
-	conn := c.conn
+	stream := c.stream
 	c.mu.RUnlock()
 
-	if conn != nil {
-		n, err := conn.Write(buf.Bytes())
-		if err != nil {
-			c.debugLog("Failed to send HeartbeatAck: %v", err)
-			c.sendEvent(Event{Type: EventError, Data: fmt.Errorf("failed to send heartbeat ack: %w", err)})
-		} else {
-			c.debugLog("Sent HeartbeatAck (%d bytes)", n)
-			c.sendEvent(Event{Type: EventHeartbeatAck, Data: time.Now()})
-		}
-	} else {
-		c.debugLog("Cannot send HeartbeatAck: connection is nil")
+	streamID, err := c.writeMessage(constants.IqStanzaTag, iq)
+	if err != nil {
+		c.debugLog("Failed to send SelectiveAck: %v", err)
+		return
 	}
+	stream.awaitConfirmation(streamID, ids)
+	c.debugLog("Sent SelectiveAck for %d persistent IDs", len(ids))
+}
+
+// sendHeartbeatAck sends a heartbeat acknowledgment to the server
+func (c *Client) sendHeartbeatAck() {
+	if _, err := c.writeMessage(constants.HeartbeatAckTag, &pb.HeartbeatAck{}); err != nil {
+		c.debugLog("Failed to send HeartbeatAck: %v", err)
+		c.sendError(fmt.Errorf("failed to send heartbeat ack: %w", err))
+		return
+	}
+
+	c.debugLog("Sent HeartbeatAck")
+	c.sendEvent(Event{Type: EventHeartbeatAck, Data: time.Now()})
 }
 
 // heartbeatLoop sends periodic heartbeat pings to keep the connection alive
-func (c *Client) heartbeatLoop() {
-	// Send heartbeat every 4 minutes (FCM expects heartbeat within ~5 min)
-	ticker := time.NewTicker(4 * time.Minute)
-	defer ticker.Stop()
+func (c *Client) heartbeatLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	timer := time.NewTimer(c.getHeartbeatInterval())
+	defer timer.Stop()
+
+	// ackTimeout is only armed while the adaptive controller waits for a HeartbeatAck
+	var ackTimeout <-chan time.Time
 
 	for {
 		select {
-		case <-c.closeChan:
+		case <-ctx.Done():
 			c.debugLog("Heartbeat loop exiting (client closed)")
 			return
-		case <-ticker.C:
+		case <-c.heartbeatReset:
+			// The interval changed, restart the countdown with the new value
+			timer.Stop()
+			timer.Reset(c.getHeartbeatInterval())
+		case <-timer.C:
+			// Drop any stale ack so only the answer to this ping counts
+			select {
+			case <-c.heartbeatAcked:
+			default:
+			}
 			c.sendHeartbeatPing()
+			if c.adaptive != nil {
+				ackTimeout = time.After(c.adaptive.ackTimeout())
+			}
+			timer.Reset(c.getHeartbeatInterval())
+		case <-c.heartbeatAcked:
+			if ackTimeout == nil {
+				continue
+			}
+			ackTimeout = nil
+			interval := c.adaptive.succeeded()
+			c.debugLog("Heartbeat acknowledged, next interval: %v", interval)
+			c.setHeartbeatInterval(interval)
+		case <-ackTimeout:
+			ackTimeout = nil
+			interval := c.adaptive.timedOut()
+			c.debugLog("Heartbeat ack timed out, falling back to %v", interval)
+			c.setHeartbeatInterval(interval)
+			c.sendError(fmt.Errorf("heartbeat ack timeout"))
+
+			// The connection is presumed dead, drop it so the client reconnects
+			c.mu.RLock()
+			conn := c.conn
+			c.mu.RUnlock()
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+}
+
+// applyHeartbeatConfig adopts the heartbeat interval the server returned at login
+func (c *Client) applyHeartbeatConfig(resp *pb.LoginResponse) {
+	if c.adaptive != nil {
+		// The adaptive controller picks the interval itself
+		c.adaptive.configure(resp.GetHeartbeatConfig())
+		return
+	}
+
+	var interval time.Duration
+	if ms := resp.GetHeartbeatConfig().GetIntervalMs(); ms > 0 {
+		interval = time.Duration(ms) * time.Millisecond
+	}
+	for _, setting := range resp.GetSetting() {
+		if setting.GetName() != "hbping" {
+			continue
+		}
+		if ms, err := strconv.ParseInt(setting.GetValue(), 10, 64); err == nil && ms > 0 {
+			interval = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	if interval == 0 {
+		return
+	}
+
+	c.debugLog("Server heartbeat interval: %v", interval)
+	c.setHeartbeatInterval(interval)
+}
+
+// setHeartbeatInterval updates the heartbeat interval and the read timeout derived from it.
+// An interval set with WithHeartbeatInterval always takes precedence.
+func (c *Client) setHeartbeatInterval(interval time.Duration) {
+	c.mu.Lock()
+	if c.heartbeatOverride > 0 {
+		interval = c.heartbeatOverride
+	}
+	changed := interval != c.heartbeatInterval
+	c.heartbeatInterval = interval
+	if !c.readTimeoutSet {
+		c.readTimeout = interval + heartbeatGrace
+	}
+	c.mu.Unlock()
+
+	if changed {
+		select {
+		case c.heartbeatReset <- struct{}{}:
+		default:
 		}
 	}
 }
 
+// getHeartbeatInterval returns the current heartbeat interval
+func (c *Client) getHeartbeatInterval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.heartbeatInterval
+}
+
+// getReadTimeout returns the current read timeout
+func (c *Client) getReadTimeout() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.readTimeout
+}
+
 // sendHeartbeatPing sends a heartbeat ping to keep the connection alive
 func (c *Client) sendHeartbeatPing() {
 	c.mu.RLock()
-	if c.closed || c.conn == nil {
-		c.mu.RUnlock()
-		return
-	}
-	conn := c.conn
+	closed := c.closed
 	c.mu.RUnlock()
 
-	ping := &pb.HeartbeatPing{}
-	data, err := proto.Marshal(ping)
-	if err != nil {
-		c.debugLog("Failed to marshal HeartbeatPing: %v", err)
+	if closed {
 		return
 	}
 
-	var buf bytes.Buffer
-	buf.WriteByte(constants.HeartbeatPingTag)
-	buf.Write(parser.EncodeVarint(uint32(len(data))))
-	buf.Write(data)
-
-	n, err := conn.Write(buf.Bytes())
-	if err != nil {
+	if _, err := c.writeMessage(constants.HeartbeatPingTag, &pb.HeartbeatPing{}); err != nil {
 		c.debugLog("Failed to send HeartbeatPing: %v", err)
 	} else {
-		c.debugLog("Sent HeartbeatPing (%d bytes)", n)
+		c.debugLog("Sent HeartbeatPing")
 	}
 }
 
-// sendEvent sends an event to the event channel
+// sendError emits an EventError carrying err
+func (c *Client) sendError(err error) {
+	c.sendEvent(Event{Type: EventError, Data: &ErrorInfo{Time: time.Now(), Err: err}})
+}
+
+// sendEvent passes an event to its registered handler, or to the event channel if there is none
 func (c *Client) sendEvent(event Event) {
-	select {
-	case c.eventChan <- event:
-	case <-time.After(time.Second):
-		c.debugLog("Warning: Event channel full, dropping event: %s", event.Type)
+	if c.dispatchLifecycle(event) {
+		return
 	}
+
+	c.queueEvent(queuedEvent{Event: event})
 }
 
-// retry attempts to reconnect to FCM with exponential backoff
-func (c *Client) retry() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// reconnect reconnects to FCM as the reconnect policy dictates until it succeeds,
+// ctx is done or the policy gives up. It reports false when the client should stop.
+// Once the credentials have been rejected, every attempt checks in before logging
+// in until a check-in succeeds.
+func (c *Client) reconnect(ctx context.Context, err error) (net.Conn, bool) {
+	recheck := false
+	for {
+		c.mu.Lock()
+		if c.closed || ctx.Err() != nil {
+			c.mu.Unlock()
+			c.debugLog("Client is closed, not reconnecting")
+			return nil, false
+		}
 
-	if c.closed {
-		c.debugLog("Client is closed, not retrying")
-		return
-	}
+		// Attempts and elapsed time run from the last successful login
+		if c.retryCount == 0 {
+			c.lostAt = time.Now()
+		}
+		c.retryCount++
+		attempt := c.retryCount
+		elapsed := time.Since(c.lostAt)
+		c.mu.Unlock()
 
-	c.retryCount++
-	timeout := c.retryCount
-	if timeout > c.maxRetryTimeout {
-		timeout = c.maxRetryTimeout
-	}
+		if errors.Is(err, ErrAuthRejected) {
+			recheck = true
+		}
+
+		delay, ok := c.reconnectPolicy.Next(attempt, elapsed, err)
+		if !ok {
+			c.debugLog("Reconnect policy gave up after %d attempts: %v", attempt-1, err)
+			if err == nil {
+				err = errors.New("reconnect policy gave up")
+			}
+			c.mu.Lock()
+			c.runErr = err
+			c.mu.Unlock()
+			return nil, false
+		}
 
-	c.debugLog("Retrying connection in %d seconds (attempt %d)...", timeout, c.retryCount)
-	time.Sleep(time.Duration(timeout) * time.Second)
+		c.setState(StateBackoff)
+		c.debugLog("Reconnecting in %v (attempt %d)...", delay, attempt)
+		c.sendEvent(Event{Type: EventReconnecting, Data: &ReconnectInfo{
+			Time:    time.Now(),
+			Attempt: attempt,
+			Delay:   delay,
+			Err:     err,
+		}})
 
-	// Attempt to reconnect
-	if err := c.connect(); err == nil {
-		c.debugLog("Reconnection successful")
-		go c.listen()
-	} else {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			c.debugLog("Context done, not reconnecting")
+			return nil, false
+		case <-timer.C:
+		}
+
+		if recheck {
+			c.setState(StateCheckingIn)
+			if err = c.checkIn(ctx); err != nil {
+				continue
+			}
+			recheck = false
+		}
+
+		var conn net.Conn
+		if conn, err = c.connect(ctx); err == nil {
+			c.debugLog("Reconnection successful")
+			return conn, true
+		}
 		c.debugLog("Reconnection failed: %v", err)
-		go c.retry()
 	}
 }
 
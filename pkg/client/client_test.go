@@ -0,0 +1,175 @@
+package client_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// testTimeout bounds every wait in these tests
+const testTimeout = 5 * time.Second
+
+// newTestClient starts a fake MCS server and creates a client pointed at it
+func newTestClient(t *testing.T, serverOpts []mcstest.Option, opts ...client.ClientOption) (*mcstest.Server, *client.Client) {
+	t.Helper()
+
+	server := mcstest.NewServer(serverOpts...)
+	t.Cleanup(server.Close)
+
+	fcmClient := client.NewClient("1234", "5678", nil, append(server.ClientOptions(), opts...)...)
+	t.Cleanup(func() { fcmClient.Close() })
+	return server, fcmClient
+}
+
+// testContext returns a context that ends with the test or after testTimeout
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// waitConn returns the next connection that logs in to server
+func waitConn(t *testing.T, server *mcstest.Server) *mcstest.Conn {
+	t.Helper()
+	conn, err := server.WaitConn(testContext(t))
+	if err != nil {
+		t.Fatalf("WaitConn: %v", err)
+	}
+	return conn
+}
+
+// sendData pushes a data message with the given persistent ID
+func sendData(t *testing.T, conn *mcstest.Conn, persistentID string) {
+	t.Helper()
+	err := conn.SendDataMessage(&pb.DataMessageStanza{
+		PersistentId: proto.String(persistentID),
+		From:         proto.String("sender"),
+		Category:     proto.String("test"),
+	})
+	if err != nil {
+		t.Fatalf("SendDataMessage: %v", err)
+	}
+}
+
+// runInBackground calls Run and returns a channel receiving its result
+func runInBackground(ctx context.Context, fcmClient *client.Client) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- fcmClient.Run(ctx)
+	}()
+	return result
+}
+
+// waitResult waits for the result of runInBackground
+func waitResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(testTimeout):
+		t.Fatal("Run did not return")
+		return nil
+	}
+}
+
+// eventually fails the test if cond does not become true within testTimeout
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ids returns n persistent IDs with the given prefix
+func ids(prefix string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("%s-%d", prefix, i)
+	}
+	return out
+}
+
+// stallingConn passes the TLS handshake through but blocks application data
+// writes until it is closed, like a peer that stopped reading
+type stallingConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+// recordTypeApplicationData is the TLS record type that carries MCS frames
+const recordTypeApplicationData = 0x17
+
+func (c *stallingConn) Write(b []byte) (int, error) {
+	if len(b) > 0 && b[0] == recordTypeApplicationData {
+		<-c.closed
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *stallingConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestConnectContextCancelledDuringLogin(t *testing.T) {
+	server := mcstest.NewServer()
+	defer server.Close()
+
+	// TLS 1.2 keeps the handshake out of application data records
+	tlsConfig := server.TLSConfig()
+	tlsConfig.MaxVersion = tls.VersionTLS12
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &stallingConn{Conn: conn, closed: make(chan struct{})}, nil
+	}
+	opts := append(server.ClientOptions(), client.WithTLSConfig(tlsConfig), client.WithDialFunc(dial))
+	fcmClient := client.NewClient("1234", "5678", nil, opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connected := make(chan error, 1)
+	go func() {
+		connected <- fcmClient.ConnectContext(ctx)
+	}()
+
+	if err := fcmClient.Wait(testContext(t), client.StateLoggingIn); err != nil {
+		t.Fatalf("Wait(LoggingIn): %v", err)
+	}
+	cancel()
+
+	select {
+	case err := <-connected:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ConnectContext = %v, want context.Canceled", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("ConnectContext did not return after cancellation")
+	}
+
+	if state := fcmClient.State(); state != client.StateClosed {
+		t.Errorf("state = %s, want Closed", state)
+	}
+	if err := fcmClient.Connect(); !errors.Is(err, client.ErrClientClosed) {
+		t.Errorf("Connect after cancellation = %v, want ErrClientClosed", err)
+	}
+}
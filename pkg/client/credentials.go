@@ -0,0 +1,75 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/palbooo/push-receiver-go/pkg/register"
+)
+
+// NewClientFromStore creates a client from the credentials saved in store.
+// Web Push keys saved with the credentials are used to decrypt notifications
+// unless opts supply their own. The dedup cache is restored from the store and
+// saved back to it when the client stops.
+func NewClientFromStore(store register.CredentialStore, opts ...ClientOption) (*Client, error) {
+	result, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credentials: %w", err)
+	}
+
+	gcmCredentials := result.FCMCredentials.GCM
+	if gcmCredentials.AndroidID == "" || gcmCredentials.SecurityToken == "" {
+		return nil, fmt.Errorf("stored credentials have no android ID or security token")
+	}
+
+	var storeOpts []ClientOption
+	if keys := result.FCMCredentials.Keys; keys.PrivateKey != "" {
+		privateKey, authSecret, err := keys.Decode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode web push keys: %w", err)
+		}
+		storeOpts = append(storeOpts, WithWebPushKeys(privateKey, authSecret))
+	}
+
+	c := NewClient(gcmCredentials.AndroidID, gcmCredentials.SecurityToken, nil, append(storeOpts, opts...)...)
+	c.credentialStore = store
+	if len(result.Dedup) > 0 {
+		if err := c.dedup.UnmarshalJSON(result.Dedup); err != nil {
+			return nil, fmt.Errorf("failed to restore dedup cache: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// SaveDedupCache saves the dedup cache with the credentials in the store the client
+// was created from. The client also does this when it stops.
+func (c *Client) SaveDedupCache() error {
+	if c.credentialStore == nil {
+		return errors.New("client was not created from a credential store")
+	}
+
+	dedup, err := c.dedup.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal dedup cache: %w", err)
+	}
+
+	result, err := c.credentialStore.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load credentials: %w", err)
+	}
+	result.Dedup = dedup
+	if err := c.credentialStore.Save(result); err != nil {
+		return fmt.Errorf("failed to save dedup cache: %w", err)
+	}
+	return nil
+}
+
+// saveDedupOnStop saves the dedup cache once the client has stopped, if it came from a store
+func (c *Client) saveDedupOnStop() {
+	if c.credentialStore == nil {
+		return
+	}
+	if err := c.SaveDedupCache(); err != nil {
+		c.debugLog("Failed to save dedup cache: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+	"github.com/palbooo/push-receiver-go/pkg/register"
+)
+
+// nextDataMessage returns the persistent ID of the next data message on events
+func nextDataMessage(t *testing.T, fcmClient *client.Client) string {
+	t.Helper()
+	ctx := testContext(t)
+	for {
+		select {
+		case event := <-fcmClient.Events():
+			if msg, ok := event.DataMessage(); ok {
+				return msg.PersistentID
+			}
+		case <-ctx.Done():
+			t.Fatal("no data message received")
+			return ""
+		}
+	}
+}
+
+func TestDedupCacheIsSavedWithCredentials(t *testing.T) {
+	server := mcstest.NewServer()
+	defer server.Close()
+
+	store := register.NewMemoryCredentialStore(&register.RegistrationResult{
+		FCMCredentials: register.FCMCredentials{
+			GCM: register.GCMCredentials{AndroidID: "1234", SecurityToken: "5678"},
+		},
+	})
+
+	// First run receives a message and stops
+	first, err := client.NewClientFromStore(store, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewClientFromStore: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := runInBackground(ctx, first)
+	sendData(t, waitConn(t, server), "a")
+	if id := nextDataMessage(t, first); id != "a" {
+		t.Fatalf("first run received %q, want a", id)
+	}
+	cancel()
+	waitResult(t, result)
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatalf("store.Load: %v", err)
+	}
+	if len(saved.Dedup) == 0 {
+		t.Fatal("dedup cache was not saved with the credentials")
+	}
+
+	// After a restart a redelivery of the same message is recognised
+	second, err := client.NewClientFromStore(store, server.ClientOptions()...)
+	if err != nil {
+		t.Fatalf("NewClientFromStore: %v", err)
+	}
+	result = runInBackground(testContext(t), second)
+	defer func() {
+		second.Close()
+		waitResult(t, result)
+	}()
+
+	conn := waitConn(t, server)
+	sendData(t, conn, "a")
+	sendData(t, conn, "b")
+	if id := nextDataMessage(t, second); id != "b" {
+		t.Errorf("second run received %q, want b", id)
+	}
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupSize is how many persistent IDs the default dedup cache remembers
+	DefaultDedupSize = 10000
+	// DefaultDedupTTL is how long the default dedup cache remembers a persistent ID
+	DefaultDedupTTL = 24 * time.Hour
+)
+
+// DedupCache remembers the persistent IDs of recently received messages so that
+// redeliveries are recognised. It holds at most size IDs, evicting the least
+// recently seen, and forgets IDs older than the TTL. Lookups are O(1).
+// It marshals to JSON so it can be saved with the credentials and restored on restart.
+type DedupCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+// dedupEntry is a persistent ID and when it was first received
+type dedupEntry struct {
+	ID       string    `json:"id"`
+	Received time.Time `json:"received"`
+}
+
+// NewDedupCache creates a dedup cache holding up to size IDs for ttl.
+// A size or ttl of zero or less means no limit.
+func NewDedupCache(size int, ttl time.Duration) *DedupCache {
+	return &DedupCache{
+		size:    size,
+		ttl:     ttl,
+		entries: list.New(),
+		index:   make(map[string]*list.Element),
+	}
+}
+
+// WithDedupCache sets the cache used to detect redelivered messages.
+// A client created with NewClientFromStore saves it with the credentials;
+// otherwise keep a reference to it to persist it between restarts.
+func WithDedupCache(cache *DedupCache) ClientOption {
+	return func(c *Client) {
+		if cache != nil {
+			c.dedup = cache
+		}
+	}
+}
+
+// Contains reports whether id was received within the TTL
+func (d *DedupCache) Contains(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	elem, ok := d.index[id]
+	if !ok {
+		return false
+	}
+	if d.expired(elem.Value.(*dedupEntry), time.Now()) {
+		d.removeElement(elem)
+		return false
+	}
+	d.entries.MoveToFront(elem)
+	return true
+}
+
+// Add records id as received now
+func (d *DedupCache) Add(id string) {
+	if id == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.add(dedupEntry{ID: id, Received: time.Now()})
+}
+
+// Remove forgets id so that a redelivery is processed again
+func (d *DedupCache) Remove(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.index[id]; ok {
+		d.removeElement(elem)
+	}
+}
+
+// Len returns how many IDs the cache holds, including any that have expired
+// but not been evicted yet
+func (d *DedupCache) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.entries.Len()
+}
+
+// MarshalJSON encodes the unexpired IDs, most recently seen first
+func (d *DedupCache) MarshalJSON() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]dedupEntry, 0, d.entries.Len())
+	for elem := d.entries.Front(); elem != nil; elem = elem.Next() {
+		if entry := elem.Value.(*dedupEntry); !d.expired(entry, now) {
+			entries = append(entries, *entry)
+		}
+	}
+	return json.Marshal(entries)
+}
+
+// UnmarshalJSON adds the encoded IDs to the cache, keeping its size and TTL
+func (d *DedupCache) UnmarshalJSON(data []byte) error {
+	var entries []dedupEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.index == nil {
+		d.entries = list.New()
+		d.index = make(map[string]*list.Element)
+	}
+
+	// Oldest first so the most recently seen end up at the front
+	now := time.Now()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].ID != "" && !d.expired(&entries[i], now) {
+			d.add(entries[i])
+		}
+	}
+	return nil
+}
+
+// add inserts or refreshes an entry and evicts whatever no longer fits
+func (d *DedupCache) add(entry dedupEntry) {
+	if elem, ok := d.index[entry.ID]; ok {
+		d.entries.MoveToFront(elem)
+		return
+	}
+	d.index[entry.ID] = d.entries.PushFront(&entry)
+
+	// The back of the list holds the least recently seen, usually also the oldest
+	now := time.Now()
+	for back := d.entries.Back(); back != nil; back = d.entries.Back() {
+		if d.size > 0 && d.entries.Len() > d.size || d.expired(back.Value.(*dedupEntry), now) {
+			d.removeElement(back)
+			continue
+		}
+		break
+	}
+}
+
+// expired reports whether entry is older than the TTL
+func (d *DedupCache) expired(entry *dedupEntry, now time.Time) bool {
+	return d.ttl > 0 && now.Sub(entry.Received) > d.ttl
+}
+
+// removeElement drops an entry from the list and the index
+func (d *DedupCache) removeElement(elem *list.Element) {
+	d.entries.Remove(elem)
+	delete(d.index, elem.Value.(*dedupEntry).ID)
+}
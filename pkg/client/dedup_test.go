@@ -0,0 +1,153 @@
+package client_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+)
+
+func TestDedupCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		ttl     time.Duration
+		run     func(t *testing.T, d *client.DedupCache)
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "evicts the least recently seen",
+			size: 3,
+			run: func(t *testing.T, d *client.DedupCache) {
+				d.Add("a")
+				d.Add("b")
+				d.Add("c")
+				d.Contains("a") // a is now the most recently seen
+				d.Add("d")
+			},
+			want:    []string{"a", "c", "d"},
+			notWant: []string{"b"},
+		},
+		{
+			name: "adding a known ID does not evict",
+			size: 2,
+			run: func(t *testing.T, d *client.DedupCache) {
+				d.Add("a")
+				d.Add("b")
+				d.Add("a")
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "forgets IDs older than the TTL",
+			ttl:  50 * time.Millisecond,
+			run: func(t *testing.T, d *client.DedupCache) {
+				d.Add("old")
+				time.Sleep(100 * time.Millisecond)
+				d.Add("new")
+			},
+			want:    []string{"new"},
+			notWant: []string{"old"},
+		},
+		{
+			name: "unmarshal skips expired entries",
+			ttl:  time.Hour,
+			run: func(t *testing.T, d *client.DedupCache) {
+				data, _ := json.Marshal([]map[string]any{
+					{"id": "fresh", "received": time.Now().Add(-time.Minute)},
+					{"id": "stale", "received": time.Now().Add(-2 * time.Hour)},
+				})
+				if err := json.Unmarshal(data, d); err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+			},
+			want:    []string{"fresh"},
+			notWant: []string{"stale"},
+		},
+		{
+			name: "remove forgets an ID",
+			run: func(t *testing.T, d *client.DedupCache) {
+				d.Add("a")
+				d.Add("b")
+				d.Remove("a")
+			},
+			want:    []string{"b"},
+			notWant: []string{"a"},
+		},
+		{
+			name: "empty IDs are never stored",
+			run: func(t *testing.T, d *client.DedupCache) {
+				d.Add("")
+				if d.Len() != 0 {
+					t.Errorf("Len = %d after adding an empty ID, want 0", d.Len())
+				}
+			},
+			notWant: []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := client.NewDedupCache(tt.size, tt.ttl)
+			tt.run(t, d)
+			for _, id := range tt.want {
+				if !d.Contains(id) {
+					t.Errorf("Contains(%q) = false, want true", id)
+				}
+			}
+			for _, id := range tt.notWant {
+				if d.Contains(id) {
+					t.Errorf("Contains(%q) = true, want false", id)
+				}
+			}
+		})
+	}
+}
+
+func TestDedupCacheRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		want    []string
+		notWant []string
+	}{
+		{"same size", 5, []string{"id-0", "id-1", "id-2", "id-3", "id-4"}, nil},
+		// The most recently seen survive a restore into a smaller cache
+		{"smaller cache", 2, []string{"id-3", "id-4"}, []string{"id-0", "id-1", "id-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := client.NewDedupCache(5, time.Hour)
+			for i := 0; i < 5; i++ {
+				original.Add(fmt.Sprintf("id-%d", i))
+			}
+
+			data, err := json.Marshal(original)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			restored := client.NewDedupCache(tt.size, time.Hour)
+			if err := json.Unmarshal(data, restored); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+
+			if restored.Len() != len(tt.want) {
+				t.Errorf("Len = %d, want %d", restored.Len(), len(tt.want))
+			}
+			for _, id := range tt.want {
+				if !restored.Contains(id) {
+					t.Errorf("Contains(%q) = false after restore", id)
+				}
+			}
+			for _, id := range tt.notWant {
+				if restored.Contains(id) {
+					t.Errorf("Contains(%q) = true after restore, want it evicted", id)
+				}
+			}
+		})
+	}
+}
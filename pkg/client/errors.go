@@ -0,0 +1,75 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrAuthRejected is matched by errors.Is when the server rejects the android ID
+// and security token. The client checks in again before reconnecting after one,
+// and the default reconnect policy gives up after three in a row.
+var ErrAuthRejected = errors.New("credentials rejected by server")
+
+// ErrCheckinFailed is matched by errors.Is when a GCM check-in fails. Reconnect
+// policies see it for an attempt that re-validated rejected credentials and did
+// not get as far as logging in.
+var ErrCheckinFailed = errors.New("check-in failed")
+
+// ErrStreamError is matched by errors.Is for every StreamError
+var ErrStreamError = errors.New("stream error")
+
+// streamErrorNotAuthorized is the stream error type sent for invalid credentials
+const streamErrorNotAuthorized = "not-authorized"
+
+// LoginError is returned when the server answers a login with an error.
+// It matches ErrAuthRejected only when the error means the credentials were
+// rejected; any other login error is worth retrying.
+type LoginError struct {
+	Code    int32
+	Message string
+	Type    string
+}
+
+func (e *LoginError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("login failed: code %d", e.Code)
+	}
+	return fmt.Sprintf("login failed: code %d: %s", e.Code, e.Message)
+}
+
+// Is reports whether target is ErrAuthRejected and the credentials were rejected
+func (e *LoginError) Is(target error) bool {
+	return target == ErrAuthRejected && e.AuthRejected()
+}
+
+// AuthRejected reports whether the server rejected the android ID and security
+// token, as opposed to failing the login for a reason that may pass
+func (e *LoginError) AuthRejected() bool {
+	switch {
+	case e.Type == streamErrorNotAuthorized:
+		return true
+	case e.Code == http.StatusUnauthorized, e.Code == http.StatusForbidden:
+		return true
+	}
+	return false
+}
+
+// StreamError is returned when the server sends a StreamErrorStanza before closing
+// the connection. It matches ErrStreamError, and ErrAuthRejected for not-authorized.
+type StreamError struct {
+	Type string
+	Text string
+}
+
+func (e *StreamError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("stream error: %s", e.Type)
+	}
+	return fmt.Sprintf("stream error: %s: %s", e.Type, e.Text)
+}
+
+// Is reports whether target is ErrStreamError, or ErrAuthRejected for a not-authorized error
+func (e *StreamError) Is(target error) bool {
+	return target == ErrStreamError || target == ErrAuthRejected && e.Type == streamErrorNotAuthorized
+}
@@ -0,0 +1,83 @@
+package client_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// failLogins answers the first n logins with code and accepts the rest
+func failLogins(n int32, code int32, message string) mcstest.Option {
+	var calls atomic.Int32
+	return mcstest.WithLoginHandler(func(req *pb.LoginRequest) *pb.LoginResponse {
+		if calls.Add(1) > n {
+			return mcstest.DefaultLoginResponse(req)
+		}
+		return &pb.LoginResponse{
+			Id: proto.String(req.GetId()),
+			Error: &pb.ErrorInfo{
+				Code:    proto.Int32(code),
+				Message: proto.String(message),
+			},
+		}
+	})
+}
+
+func TestTransientLoginErrorIsRetried(t *testing.T) {
+	server, fcmClient := newTestClient(t, []mcstest.Option{failLogins(1, 503, "try later")})
+
+	result := runInBackground(testContext(t), fcmClient)
+
+	// The second login succeeds
+	waitConn(t, server)
+	if logins := len(server.LoginRequests()); logins != 2 {
+		t.Errorf("logins = %d, want 2", logins)
+	}
+
+	fcmClient.Close()
+	if err := waitResult(t, result); errors.Is(err, client.ErrAuthRejected) {
+		t.Errorf("Run error = %v, want no auth rejection", err)
+	}
+}
+
+func TestRejectedCredentialsStopTheClient(t *testing.T) {
+	_, fcmClient := newTestClient(t, []mcstest.Option{failLogins(100, 401, "bad token")},
+		client.WithReconnectPolicy(&client.CircuitBreaker{
+			Policy:       &client.ExponentialBackoff{Initial: time.Millisecond},
+			AuthFailures: 1,
+		}),
+	)
+
+	err := waitResult(t, runInBackground(testContext(t), fcmClient))
+	if !errors.Is(err, client.ErrAuthRejected) {
+		t.Fatalf("Run error = %v, want ErrAuthRejected", err)
+	}
+
+	var loginErr *client.LoginError
+	if !errors.As(err, &loginErr) || loginErr.Code != 401 {
+		t.Errorf("Run error = %#v, want a LoginError with code 401", err)
+	}
+}
+
+func TestLoginErrorAuthRejected(t *testing.T) {
+	for _, tt := range []struct {
+		err  client.LoginError
+		want bool
+	}{
+		{client.LoginError{Code: 401}, true},
+		{client.LoginError{Code: 403}, true},
+		{client.LoginError{Code: 1, Type: "not-authorized"}, true},
+		{client.LoginError{Code: 503, Message: "try later"}, false},
+		{client.LoginError{Code: 500}, false},
+	} {
+		if got := errors.Is(&tt.err, client.ErrAuthRejected); got != tt.want {
+			t.Errorf("errors.Is(%v, ErrAuthRejected) = %v, want %v", &tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"time"
+
+	pb "github.com/palbooo/push-receiver-go/proto"
+)
+
+// DataMessage is the payload of EventDataReceived and carries every DataMessageStanza field
+type DataMessage struct {
+	// ID is the message ID set by the sender
+	ID string
+	// PersistentID identifies the message for acknowledgement and deduplication
+	PersistentID string
+	// From is the project ID of the sender
+	From string
+	// To is the target of the message
+	To string
+	// Category is the package name of the target application
+	Category string
+	// CollapseKey is the collapse key (the stanza's token field)
+	CollapseKey string
+	// AppData holds the user data key/value pairs
+	AppData map[string]string
+	// RawData is the binary payload of the message
+	RawData []byte
+	// TTL is the time to live of the message
+	TTL time.Duration
+	// Sent is when the sending app sent the message, zero if unknown
+	Sent time.Time
+	// Queued is how long the message was queued before delivery
+	Queued time.Duration
+	// ImmediateAck is set when the server requested an immediate ack
+	ImmediateAck bool
+	// RegID is the registration ID the message was sent to
+	RegID string
+	// DeviceUserID is the serial number of the target user
+	DeviceUserID int64
+
+	acker *acker
+}
+
+// Notification is the payload of EventNotificationReceived, an encrypted Web Push message
+type Notification struct {
+	DataMessage
+	// Encoding is the Web Push content encoding, aes128gcm or aesgcm
+	Encoding string
+	// Decrypted is the decrypted payload, nil when no keys are configured or decryption failed
+	Decrypted []byte
+}
+
+// ConnectInfo is the payload of EventConnect
+type ConnectInfo struct {
+	// Time is when the login completed
+	Time time.Time
+	// ServerTime is the server's clock at login, zero if not reported
+	ServerTime time.Time
+	// HeartbeatInterval is the heartbeat interval in effect for the connection
+	HeartbeatInterval time.Duration
+}
+
+// DisconnectInfo is the payload of EventDisconnect
+type DisconnectInfo struct {
+	// Time is when the connection was lost
+	Time time.Time
+	// Err is why the connection was lost, nil when the client was closed
+	Err error
+}
+
+// ReconnectInfo is the payload of EventReconnecting
+type ReconnectInfo struct {
+	// Time is when the attempt was scheduled
+	Time time.Time
+	// Attempt counts the attempts since the connection was lost, starting at 1
+	Attempt int
+	// Delay is how long the client waits before the attempt
+	Delay time.Duration
+	// Err is why the connection or the previous attempt failed
+	Err error
+}
+
+// ErrorInfo is the payload of EventError and EventCheckinFailed
+type ErrorInfo struct {
+	// Time is when the error occurred
+	Time time.Time
+	// Err is the error itself
+	Err error
+}
+
+// Error implements the error interface so ErrorInfo prints like the error it wraps
+func (e *ErrorInfo) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ErrorInfo) Unwrap() error {
+	return e.Err
+}
+
+// DataMessage returns the payload of an EventDataReceived event
+func (e Event) DataMessage() (*DataMessage, bool) {
+	msg, ok := e.Data.(*DataMessage)
+	return msg, ok
+}
+
+// Notification returns the payload of an EventNotificationReceived event
+func (e Event) Notification() (*Notification, bool) {
+	n, ok := e.Data.(*Notification)
+	return n, ok
+}
+
+// ConnectInfo returns the payload of an EventConnect event
+func (e Event) ConnectInfo() (*ConnectInfo, bool) {
+	info, ok := e.Data.(*ConnectInfo)
+	return info, ok
+}
+
+// DisconnectInfo returns the payload of an EventDisconnect event
+func (e Event) DisconnectInfo() (*DisconnectInfo, bool) {
+	info, ok := e.Data.(*DisconnectInfo)
+	return info, ok
+}
+
+// ReconnectInfo returns the payload of an EventReconnecting event
+func (e Event) ReconnectInfo() (*ReconnectInfo, bool) {
+	info, ok := e.Data.(*ReconnectInfo)
+	return info, ok
+}
+
+// ErrorInfo returns the payload of an EventError or EventCheckinFailed event
+func (e Event) ErrorInfo() (*ErrorInfo, bool) {
+	info, ok := e.Data.(*ErrorInfo)
+	return info, ok
+}
+
+// newDataMessage converts a DataMessageStanza into its event payload
+func newDataMessage(msg *pb.DataMessageStanza) *DataMessage {
+	appData := make(map[string]string, len(msg.GetAppData()))
+	for _, data := range msg.GetAppData() {
+		appData[data.GetKey()] = data.GetValue()
+	}
+
+	var sent time.Time
+	if msg.Sent != nil {
+		sent = time.Unix(msg.GetSent(), 0)
+	}
+
+	return &DataMessage{
+		ID:           msg.GetId(),
+		PersistentID: msg.GetPersistentId(),
+		From:         msg.GetFrom(),
+		To:           msg.GetTo(),
+		Category:     msg.GetCategory(),
+		CollapseKey:  msg.GetToken(),
+		AppData:      appData,
+		RawData:      msg.GetRawData(),
+		TTL:          time.Duration(msg.GetTtl()) * time.Second,
+		Sent:         sent,
+		Queued:       time.Duration(msg.GetQueued()) * time.Second,
+		ImmediateAck: msg.GetImmediateAck(),
+		RegID:        msg.GetRegId(),
+		DeviceUserID: msg.GetDeviceUserId(),
+	}
+}
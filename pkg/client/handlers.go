@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// handlers holds the callbacks registered on the client
+type handlers struct {
+	data         func(*DataMessage) error
+	notification func(*Notification) error
+	connect      func(*ConnectInfo)
+	disconnect   func(*DisconnectInfo)
+	err          func(*ErrorInfo)
+	checkin      func(*ErrorInfo)
+}
+
+// handlerJob is a message waiting for its handler
+type handlerJob struct {
+	persistentID string
+	msg          *DataMessage
+	run          func() error
+}
+
+// WithHandlerConcurrency sets how many data and notification handlers may run at once.
+// The default of 1 invokes handlers in the order messages arrive.
+func WithHandlerConcurrency(workers int) ClientOption {
+	return func(c *Client) {
+		if workers > 0 {
+			c.handlerWorkers = workers
+		}
+	}
+}
+
+// OnData registers the handler for data messages. Events it handles are not sent to Events().
+// The message is acknowledged to the server only when the handler returns nil; on error
+// or panic it is left unacknowledged so the server redelivers it.
+func (c *Client) OnData(handler func(*DataMessage) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers.data = handler
+}
+
+// OnNotification registers the handler for Web Push notifications.
+// It is acknowledged like OnData.
+func (c *Client) OnNotification(handler func(*Notification) error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers.notification = handler
+}
+
+// OnConnect registers the handler called each time the client logs in.
+//
+// OnConnect, OnDisconnect, OnError and OnCheckinFailed handlers run on the
+// goroutine that reads from the connection, in the order the events occur.
+// They must return quickly and must not block, e.g. on Events(): the client
+// neither reads messages nor reconnects until they return. Calling Close from
+// them is safe.
+func (c *Client) OnConnect(handler func(*ConnectInfo)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers.connect = handler
+}
+
+// OnDisconnect registers the handler called each time the connection is lost
+func (c *Client) OnDisconnect(handler func(*DisconnectInfo)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers.disconnect = handler
+}
+
+// OnError registers the handler called for every EventError, including handler failures
+func (c *Client) OnError(handler func(*ErrorInfo)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers.err = handler
+}
+
+// OnCheckinFailed registers the handler called for every EventCheckinFailed
+func (c *Client) OnCheckinFailed(handler func(*ErrorInfo)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers.checkin = handler
+}
+
+// startHandlerWorkers starts the goroutines that run data and notification handlers
+func (c *Client) startHandlerWorkers(ctx context.Context) {
+	c.wg.Add(c.handlerWorkers)
+	for i := 0; i < c.handlerWorkers; i++ {
+		go c.handlerWorker(ctx)
+	}
+}
+
+// handlerWorker runs queued handlers and acknowledges the messages they accept
+func (c *Client) handlerWorker(ctx context.Context) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-c.handlerJobs:
+			if err := safeCall(job.run); err != nil {
+				c.debugLog("Handler failed for %s, leaving it unacknowledged: %v", job.persistentID, err)
+				c.sendError(fmt.Errorf("handler failed for message %s: %w", job.persistentID, err))
+				if c.manualAck {
+					// Leaves the message alone if the handler already acked it
+					job.msg.Nack()
+				} else {
+					c.nack(job.persistentID)
+				}
+				continue
+			}
+			c.ackProcessed(job.persistentID)
+		}
+	}
+}
+
+// dispatchMessage hands a data or notification event to its handler.
+// It reports false when no handler is registered for the event.
+func (c *Client) dispatchMessage(event Event, persistentID string) bool {
+	c.mu.RLock()
+	h := c.handlers
+	done := c.done
+	c.mu.RUnlock()
+
+	var msg *DataMessage
+	var run func() error
+	switch data := event.Data.(type) {
+	case *DataMessage:
+		if h.data == nil {
+			return false
+		}
+		msg = data
+		run = func() error { return h.data(data) }
+	case *Notification:
+		if h.notification == nil {
+			return false
+		}
+		msg = &data.DataMessage
+		run = func() error { return h.notification(data) }
+	default:
+		return false
+	}
+
+	select {
+	case c.handlerJobs <- handlerJob{persistentID: persistentID, msg: msg, run: run}:
+	case <-done:
+		// Shutting down, forget the message so the server redelivers it
+		c.nack(persistentID)
+	}
+	return true
+}
+
+// dispatchLifecycle calls the connect, disconnect, error or check-in handler for event.
+// It reports false when no handler is registered for the event.
+func (c *Client) dispatchLifecycle(event Event) bool {
+	c.mu.RLock()
+	h := c.handlers
+	c.mu.RUnlock()
+
+	var run func()
+	switch data := event.Data.(type) {
+	case *ConnectInfo:
+		if h.connect == nil {
+			return false
+		}
+		run = func() { h.connect(data) }
+	case *DisconnectInfo:
+		if h.disconnect == nil {
+			return false
+		}
+		run = func() { h.disconnect(data) }
+	case *ErrorInfo:
+		handler := h.err
+		if event.Type == EventCheckinFailed {
+			handler = h.checkin
+		}
+		if handler == nil {
+			return false
+		}
+		run = func() { handler(data) }
+	default:
+		return false
+	}
+
+	if err := safeCall(func() error { run(); return nil }); err != nil {
+		c.debugLog("%s handler failed: %v", event.Type, err)
+		if event.Type != EventError {
+			c.sendError(fmt.Errorf("%s handler failed: %w", event.Type, err))
+		}
+	}
+	return true
+}
+
+// safeCall runs fn, turning a panic into an error
+func safeCall(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return fn()
+}
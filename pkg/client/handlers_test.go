@@ -0,0 +1,58 @@
+package client_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+)
+
+func TestCheckinFailureGoesToOnCheckinFailed(t *testing.T) {
+	// Nothing listens on the discard port
+	_, fcmClient := newTestClient(t, nil, client.WithCheckinURL("http://127.0.0.1:9/checkin"))
+
+	checkinFailed := make(chan *client.ErrorInfo, 1)
+	fcmClient.OnCheckinFailed(func(info *client.ErrorInfo) {
+		checkinFailed <- info
+	})
+	errs := make(chan *client.ErrorInfo, 1)
+	fcmClient.OnError(func(info *client.ErrorInfo) {
+		errs <- info
+	})
+
+	if err := fcmClient.ConnectContext(testContext(t)); err == nil {
+		t.Fatal("ConnectContext succeeded without a check-in server")
+	}
+
+	select {
+	case info := <-checkinFailed:
+		if info.Err == nil {
+			t.Error("OnCheckinFailed got no error")
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("OnCheckinFailed was not called")
+	}
+	select {
+	case info := <-errs:
+		t.Errorf("OnError got the check-in failure %v", info)
+	default:
+	}
+}
+
+func TestCloseFromOnDisconnect(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil, client.WithReconnectPolicy(fixedDelay{}))
+	fcmClient.OnDisconnect(func(*client.DisconnectInfo) {
+		fcmClient.Close()
+	})
+
+	result := runInBackground(testContext(t), fcmClient)
+	waitConn(t, server).Close()
+
+	if err := waitResult(t, result); err != nil && !errors.Is(err, client.ErrClientClosed) {
+		t.Errorf("Run = %v, want nil or ErrClientClosed", err)
+	}
+	if logins := len(server.LoginRequests()); logins != 1 {
+		t.Errorf("logins = %d, want 1: the client reconnected after Close", logins)
+	}
+}
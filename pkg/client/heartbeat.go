@@ -0,0 +1,166 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// AdaptiveHeartbeatConfig configures the adaptive heartbeat controller
+type AdaptiveHeartbeatConfig struct {
+	// MinInterval is the interval probing starts from and never drops below
+	MinInterval time.Duration
+	// MaxInterval is the longest interval that will be probed
+	MaxInterval time.Duration
+	// Step is how much the interval grows after each acknowledged ping
+	Step time.Duration
+	// AckTimeout is how long to wait for the server to acknowledge a ping
+	AckTimeout time.Duration
+}
+
+// DefaultAdaptiveHeartbeatConfig returns the default adaptive heartbeat configuration
+func DefaultAdaptiveHeartbeatConfig() AdaptiveHeartbeatConfig {
+	return AdaptiveHeartbeatConfig{
+		MinInterval: time.Minute,
+		MaxInterval: 28 * time.Minute,
+		Step:        time.Minute,
+		AckTimeout:  time.Minute,
+	}
+}
+
+// WithAdaptiveHeartbeat enables the adaptive heartbeat controller, which probes for
+// the longest ping interval the network path tolerates. Zero fields in config
+// fall back to DefaultAdaptiveHeartbeatConfig.
+func WithAdaptiveHeartbeat(config AdaptiveHeartbeatConfig) ClientOption {
+	return func(c *Client) {
+		c.adaptive = newAdaptiveHeartbeat(config)
+	}
+}
+
+// adaptiveHeartbeat grows the heartbeat interval while pings are acknowledged
+// and settles on the last good interval once a ping times out
+type adaptiveHeartbeat struct {
+	mu     sync.Mutex
+	config AdaptiveHeartbeatConfig
+	// interval is the interval currently being tried
+	interval time.Duration
+	// lastGood is the longest interval known to survive
+	lastGood time.Duration
+	// ceiling is the shortest interval known to fail
+	ceiling time.Duration
+	// ip and uploadStat come from the server's HeartbeatConfig
+	ip         string
+	uploadStat bool
+	stat       *pb.HeartbeatStat
+}
+
+// newAdaptiveHeartbeat creates a controller, filling in defaults for zero fields
+func newAdaptiveHeartbeat(config AdaptiveHeartbeatConfig) *adaptiveHeartbeat {
+	defaults := DefaultAdaptiveHeartbeatConfig()
+	if config.MinInterval <= 0 {
+		config.MinInterval = defaults.MinInterval
+	}
+	if config.MaxInterval < config.MinInterval {
+		config.MaxInterval = max(defaults.MaxInterval, config.MinInterval)
+	}
+	if config.Step <= 0 {
+		config.Step = defaults.Step
+	}
+	if config.AckTimeout <= 0 {
+		config.AckTimeout = defaults.AckTimeout
+	}
+
+	return &adaptiveHeartbeat{
+		config:   config,
+		interval: config.MinInterval,
+		lastGood: config.MinInterval,
+		ceiling:  config.MaxInterval + config.Step,
+	}
+}
+
+// current returns the interval to wait before the next ping
+func (h *adaptiveHeartbeat) current() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.interval
+}
+
+// ackTimeout returns how long to wait for a ping to be acknowledged
+func (h *adaptiveHeartbeat) ackTimeout() time.Duration {
+	return h.config.AckTimeout
+}
+
+// succeeded records an acknowledged ping and returns the next interval to try
+func (h *adaptiveHeartbeat) succeeded() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.record(false)
+	if h.interval > h.lastGood {
+		h.lastGood = h.interval
+	}
+
+	if next := h.interval + h.config.Step; next < h.ceiling && next <= h.config.MaxInterval {
+		h.interval = next
+	}
+	return h.interval
+}
+
+// timedOut records a ping that was never acknowledged and returns the interval to fall back to
+func (h *adaptiveHeartbeat) timedOut() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.record(true)
+	if h.interval < h.ceiling {
+		h.ceiling = h.interval
+	}
+
+	h.interval = h.lastGood
+	if h.interval >= h.ceiling {
+		// Even the last good interval failed, start probing from the bottom again
+		h.interval = h.config.MinInterval
+		h.lastGood = h.config.MinInterval
+	}
+	return h.interval
+}
+
+// record stores the outcome of the current interval for upload to the server
+func (h *adaptiveHeartbeat) record(timeout bool) {
+	h.stat = &pb.HeartbeatStat{
+		Ip:         proto.String(h.ip),
+		Timeout:    proto.Bool(timeout),
+		IntervalMs: proto.Int32(int32(h.interval.Milliseconds())),
+	}
+}
+
+// configure applies the HeartbeatConfig returned in the LoginResponse
+func (h *adaptiveHeartbeat) configure(config *pb.HeartbeatConfig) {
+	if config == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.uploadStat = config.GetUploadStat()
+	if config.Ip != nil {
+		h.ip = config.GetIp()
+	}
+}
+
+// pendingStat returns the HeartbeatStat to include in the next LoginRequest,
+// or nil when the server has not asked for one
+func (h *adaptiveHeartbeat) pendingStat() *pb.HeartbeatStat {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.uploadStat || h.stat == nil {
+		return nil
+	}
+	stat := h.stat
+	h.stat = nil
+	return stat
+}
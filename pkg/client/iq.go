@@ -0,0 +1,179 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/palbooo/push-receiver-go/internal/constants"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Extension IDs of the IQ extensions the client understands
+const (
+	IQExtensionSelectiveAck int32 = constants.SelectiveAckExtension
+	IQExtensionStreamAck    int32 = constants.StreamAckExtension
+)
+
+// Error codes sent in IQ_ERROR replies
+const (
+	// IQErrorUnsupported is sent for extensions nobody handles
+	IQErrorUnsupported int32 = 501
+	// IQErrorInternal is sent when a handler fails without an *IQError
+	IQErrorInternal int32 = 500
+)
+
+// IQ is an IqStanza GET or SET request sent by the server
+type IQ struct {
+	// ID must be echoed in the reply, the client takes care of that
+	ID string
+	// Set is true for SET requests and false for GET requests
+	Set bool
+	// ExtensionID identifies the extension, see the IQExtension constants
+	ExtensionID int32
+	// Data is the raw extension payload
+	Data []byte
+	// Value is the decoded payload for known extensions: *SelectiveAck or *StreamAck
+	Value interface{}
+}
+
+// SelectiveAck is the decoded payload of an IQExtensionSelectiveAck request
+type SelectiveAck struct {
+	// IDs are the persistent IDs being acknowledged
+	IDs []string
+}
+
+// StreamAck is the decoded payload of an IQExtensionStreamAck request
+type StreamAck struct{}
+
+// IQHandler answers a server IQ request. The returned data is sent back as the
+// extension payload of the RESULT reply; an error is sent back as IQ_ERROR.
+type IQHandler func(iq *IQ) ([]byte, error)
+
+// IQError lets an IQHandler choose the code of the IQ_ERROR reply
+type IQError struct {
+	Code    int32
+	Message string
+}
+
+func (e *IQError) Error() string {
+	return fmt.Sprintf("iq error %d: %s", e.Code, e.Message)
+}
+
+// OnIQ registers the handler for server IQ requests with the given extension ID.
+// Known extensions are answered with an empty RESULT unless a handler is registered;
+// unknown ones get an IQ_ERROR.
+func (c *Client) OnIQ(extensionID int32, handler IQHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.iqHandlers == nil {
+		c.iqHandlers = make(map[int32]IQHandler)
+	}
+	c.iqHandlers[extensionID] = handler
+}
+
+// handleIq dispatches a server IqStanza and sends the reply
+func (c *Client) handleIq(stanza *pb.IqStanza) {
+	switch stanza.GetType() {
+	case pb.IqStanza_RESULT:
+		c.debugLog("Received IQ result (id: %q)", stanza.GetId())
+		return
+	case pb.IqStanza_IQ_ERROR:
+		c.debugLog("Received IQ error (id: %q): %s", stanza.GetId(), stanza.GetError().GetMessage())
+		return
+	}
+
+	if stanza.GetExtension() == nil {
+		c.replyIq(stanza, nil, &IQError{Code: IQErrorUnsupported, Message: "missing extension"})
+		return
+	}
+
+	iq := &IQ{
+		ID:          stanza.GetId(),
+		Set:         stanza.GetType() == pb.IqStanza_SET,
+		ExtensionID: stanza.GetExtension().GetId(),
+		Data:        stanza.GetExtension().GetData(),
+	}
+	c.debugLog("Received IQ request for extension %d (id: %q)", iq.ExtensionID, iq.ID)
+
+	known, err := decodeIqExtension(iq)
+	if err != nil {
+		c.replyIq(stanza, nil, &IQError{Code: IQErrorInternal, Message: err.Error()})
+		return
+	}
+
+	c.mu.RLock()
+	handler := c.iqHandlers[iq.ExtensionID]
+	c.mu.RUnlock()
+
+	if handler == nil {
+		if !known {
+			c.replyIq(stanza, nil, &IQError{
+				Code:    IQErrorUnsupported,
+				Message: fmt.Sprintf("unsupported extension %d", iq.ExtensionID),
+			})
+			return
+		}
+		c.replyIq(stanza, nil, nil)
+		return
+	}
+
+	var data []byte
+	err = safeCall(func() error {
+		var handlerErr error
+		data, handlerErr = handler(iq)
+		return handlerErr
+	})
+	c.replyIq(stanza, data, err)
+}
+
+// decodeIqExtension fills in iq.Value for known extensions.
+// It reports whether the extension is known.
+func decodeIqExtension(iq *IQ) (bool, error) {
+	switch iq.ExtensionID {
+	case IQExtensionSelectiveAck:
+		var ack pb.SelectiveAck
+		if err := proto.Unmarshal(iq.Data, &ack); err != nil {
+			return true, fmt.Errorf("failed to decode SelectiveAck: %w", err)
+		}
+		iq.Value = &SelectiveAck{IDs: ack.GetId()}
+		return true, nil
+
+	case IQExtensionStreamAck:
+		iq.Value = &StreamAck{}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// replyIq answers a server IQ request with RESULT, or IQ_ERROR when err is set
+func (c *Client) replyIq(req *pb.IqStanza, data []byte, err error) {
+	reply := &pb.IqStanza{
+		Type: pb.IqStanza_RESULT.Enum(),
+		Id:   proto.String(req.GetId()),
+	}
+
+	if err != nil {
+		iqErr := &IQError{Code: IQErrorInternal, Message: err.Error()}
+		errors.As(err, &iqErr)
+
+		reply.Type = pb.IqStanza_IQ_ERROR.Enum()
+		reply.Error = &pb.ErrorInfo{
+			Code:    proto.Int32(iqErr.Code),
+			Message: proto.String(iqErr.Message),
+		}
+	} else if data != nil {
+		reply.Extension = &pb.Extension{
+			Id:   proto.Int32(req.GetExtension().GetId()),
+			Data: data,
+		}
+	}
+
+	if _, err := c.writeMessage(constants.IqStanzaTag, reply); err != nil {
+		c.debugLog("Failed to reply to IQ %q: %v", req.GetId(), err)
+		return
+	}
+	c.debugLog("Sent IQ %s (id: %q)", reply.GetType(), req.GetId())
+}
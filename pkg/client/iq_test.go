@@ -0,0 +1,120 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// iqReplies returns the IqStanzas the client sent in reply to the given ID
+func iqReplies(conn *mcstest.Conn, id string) []*pb.IqStanza {
+	var replies []*pb.IqStanza
+	for _, frame := range conn.Frames() {
+		if iq, ok := frame.Message.(*pb.IqStanza); ok && iq.GetId() == id {
+			replies = append(replies, iq)
+		}
+	}
+	return replies
+}
+
+// sendIq sends an IqStanza of the given type to the client
+func sendIq(t *testing.T, conn *mcstest.Conn, id string, iqType pb.IqStanza_IqType, extensionID int32) {
+	t.Helper()
+	iq := &pb.IqStanza{
+		Id:   proto.String(id),
+		Type: iqType.Enum(),
+		Extension: &pb.Extension{
+			Id:   proto.Int32(extensionID),
+			Data: []byte{},
+		},
+	}
+	if err := conn.SendIq(iq); err != nil {
+		t.Fatalf("SendIq: %v", err)
+	}
+}
+
+// waitIqReply waits for the single reply to the IQ with the given ID
+func waitIqReply(t *testing.T, conn *mcstest.Conn, id string) *pb.IqStanza {
+	t.Helper()
+	eventually(t, "the reply to IQ "+id, func() bool {
+		return len(iqReplies(conn, id)) > 0
+	})
+	replies := iqReplies(conn, id)
+	if len(replies) != 1 {
+		t.Fatalf("replies to IQ %s = %d, want 1", id, len(replies))
+	}
+	return replies[0]
+}
+
+func TestIqRequestsAreAnswered(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil)
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	conn := waitConn(t, server)
+
+	for _, tt := range []struct {
+		id          string
+		iqType      pb.IqStanza_IqType
+		extensionID int32
+		want        pb.IqStanza_IqType
+		code        int32
+	}{
+		{"get", pb.IqStanza_GET, client.IQExtensionStreamAck, pb.IqStanza_RESULT, 0},
+		{"set", pb.IqStanza_SET, client.IQExtensionStreamAck, pb.IqStanza_RESULT, 0},
+		{"unknown", pb.IqStanza_GET, 99, pb.IqStanza_IQ_ERROR, client.IQErrorUnsupported},
+	} {
+		sendIq(t, conn, tt.id, tt.iqType, tt.extensionID)
+		reply := waitIqReply(t, conn, tt.id)
+		if reply.GetType() != tt.want {
+			t.Errorf("%s: reply type = %v, want %v", tt.id, reply.GetType(), tt.want)
+		}
+		if code := reply.GetError().GetCode(); code != tt.code {
+			t.Errorf("%s: reply error code = %d, want %d", tt.id, code, tt.code)
+		}
+	}
+}
+
+func TestIqRepliesAreNotAnswered(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil)
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	conn := waitConn(t, server)
+
+	sendIq(t, conn, "result", pb.IqStanza_RESULT, client.IQExtensionStreamAck)
+	sendIq(t, conn, "error", pb.IqStanza_IQ_ERROR, client.IQExtensionStreamAck)
+
+	// IQs are handled in order, so once this one is answered the others were seen
+	sendIq(t, conn, "marker", pb.IqStanza_GET, client.IQExtensionStreamAck)
+	waitIqReply(t, conn, "marker")
+
+	for _, id := range []string{"result", "error"} {
+		if replies := iqReplies(conn, id); len(replies) != 0 {
+			t.Errorf("replies to the %s IQ = %v, want none", id, replies)
+		}
+	}
+}
+
+func TestIqHandlerData(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil)
+	fcmClient.OnIQ(42, func(iq *client.IQ) ([]byte, error) {
+		return []byte("pong"), nil
+	})
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	conn := waitConn(t, server)
+
+	sendIq(t, conn, "custom", pb.IqStanza_GET, 42)
+	reply := waitIqReply(t, conn, "custom")
+	if reply.GetType() != pb.IqStanza_RESULT {
+		t.Errorf("reply type = %v, want RESULT", reply.GetType())
+	}
+	if ext := reply.GetExtension(); ext.GetId() != 42 || string(ext.GetData()) != "pong" {
+		t.Errorf("reply extension = %v, want 42 with pong", ext)
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ReconnectPolicy decides whether and when the client reconnects after losing its connection
+type ReconnectPolicy interface {
+	// Next returns the delay before reconnect attempt number attempt (starting at 1),
+	// or false to stop reconnecting. elapsed is the time since the connection was lost
+	// and err why the previous connection or attempt failed. After ErrAuthRejected the
+	// client checks in before logging in again; if that fails err matches ErrCheckinFailed.
+	Next(attempt int, elapsed time.Duration, err error) (time.Duration, bool)
+	// Reset is called once the client has logged in again
+	Reset()
+}
+
+// WithReconnectPolicy sets how the client reconnects, DefaultReconnectPolicy() by default
+func WithReconnectPolicy(policy ReconnectPolicy) ClientOption {
+	return func(c *Client) {
+		if policy != nil {
+			c.reconnectPolicy = policy
+		}
+	}
+}
+
+// DefaultReconnectPolicy retries with jittered exponential backoff between one second
+// and one minute. It gives up once the credentials have been rejected three times in
+// a row, so Run returns an error matching ErrAuthRejected; use a CircuitBreaker with
+// a Cooldown to keep trying instead.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return &CircuitBreaker{
+		Policy: &ExponentialBackoff{
+			Initial:    time.Second,
+			Max:        time.Minute,
+			Multiplier: 2,
+		},
+		AuthFailures: 3,
+	}
+}
+
+// ExponentialBackoff waits a random time between zero and an exponentially growing
+// ceiling before each attempt ("full jitter"), so clients that lost their
+// connection together do not reconnect in lockstep.
+// It retries every error, including rejected credentials.
+type ExponentialBackoff struct {
+	// Initial is the ceiling for the first attempt
+	Initial time.Duration
+	// Max caps the ceiling
+	Max time.Duration
+	// Multiplier grows the ceiling after every attempt, 2 if unset
+	Multiplier float64
+	// MaxAttempts stops reconnecting after this many attempts, zero means no limit
+	MaxAttempts int
+	// MaxElapsed stops reconnecting once the connection has been down this long, zero means no limit
+	MaxElapsed time.Duration
+}
+
+// Next returns a random delay up to Initial*Multiplier^(attempt-1), capped at Max
+func (b *ExponentialBackoff) Next(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+		return 0, false
+	}
+	if b.MaxElapsed > 0 && elapsed >= b.MaxElapsed {
+		return 0, false
+	}
+
+	multiplier := b.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+
+	ceiling := float64(b.Initial) * math.Pow(multiplier, float64(attempt-1))
+	if b.Max > 0 && ceiling > float64(b.Max) {
+		ceiling = float64(b.Max)
+	}
+	if ceiling < 1 {
+		return 0, true
+	}
+	if ceiling > math.MaxInt64 {
+		ceiling = math.MaxInt64
+	}
+
+	return time.Duration(rand.Int64N(int64(ceiling))), true
+}
+
+// Reset does nothing, the backoff only depends on the attempt number
+func (b *ExponentialBackoff) Reset() {}
+
+// CircuitBreaker wraps a policy and trips after the credentials have been rejected
+// AuthFailures times in a row. Once tripped it stops reconnecting, or with a
+// Cooldown it makes a single attempt per Cooldown until a login succeeds.
+// A failed check-in neither counts as a rejection nor breaks the run of them.
+type CircuitBreaker struct {
+	// Policy decides the delay while the breaker is closed
+	Policy ReconnectPolicy
+	// AuthFailures is how many consecutive auth failures trip the breaker, 1 if unset
+	AuthFailures int
+	// Cooldown is how long a tripped breaker waits before trying again, zero to give up
+	Cooldown time.Duration
+
+	mu       sync.Mutex
+	failures int
+}
+
+// Next counts auth failures and defers to Policy until the breaker trips
+func (b *CircuitBreaker) Next(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	b.mu.Lock()
+	switch {
+	case errors.Is(err, ErrAuthRejected):
+		b.failures++
+	case errors.Is(err, ErrCheckinFailed):
+		// Says nothing about the credentials either way
+	case err != nil:
+		b.failures = 0
+	}
+	failures := b.failures
+	b.mu.Unlock()
+
+	threshold := b.AuthFailures
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if failures >= threshold {
+		if b.Cooldown <= 0 {
+			return 0, false
+		}
+		return b.Cooldown, true
+	}
+
+	return b.Policy.Next(attempt, elapsed, err)
+}
+
+// Reset closes the breaker and resets the wrapped policy
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	b.failures = 0
+	b.mu.Unlock()
+
+	b.Policy.Reset()
+}
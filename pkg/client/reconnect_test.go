@@ -0,0 +1,239 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+)
+
+func TestCheckinBeforeReconnectAfterAuthFailure(t *testing.T) {
+	server, fcmClient := newTestClient(t, []mcstest.Option{failLogins(100, 401, "bad token")},
+		client.WithReconnectPolicy(&client.CircuitBreaker{
+			Policy:       &client.ExponentialBackoff{Initial: time.Millisecond},
+			AuthFailures: 2,
+		}),
+	)
+
+	err := waitResult(t, runInBackground(testContext(t), fcmClient))
+	if !errors.Is(err, client.ErrAuthRejected) {
+		t.Fatalf("Run error = %v, want ErrAuthRejected", err)
+	}
+
+	// The initial check-in, then one before the second login. The policy gives
+	// up after the second rejection without checking in again.
+	if logins := len(server.LoginRequests()); logins != 2 {
+		t.Errorf("logins = %d, want 2", logins)
+	}
+	if checkins := server.Checkins(); checkins != 2 {
+		t.Errorf("check-ins = %d, want 2", checkins)
+	}
+}
+
+// recordingPolicy reconnects without delay and records every error it sees,
+// with the number of check-ins the server had received at the time
+type recordingPolicy struct {
+	server  *mcstest.Server
+	delay   time.Duration
+	maxErrs int
+
+	mu       sync.Mutex
+	errs     []error
+	checkins []int
+}
+
+func (p *recordingPolicy) Next(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errs = append(p.errs, err)
+	p.checkins = append(p.checkins, p.server.Checkins())
+	return p.delay, p.maxErrs == 0 || len(p.errs) < p.maxErrs
+}
+
+func (p *recordingPolicy) Reset() {}
+
+// seen returns the recorded errors and check-in counts
+func (p *recordingPolicy) seen() ([]error, []int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]error(nil), p.errs...), append([]int(nil), p.checkins...)
+}
+
+func TestCheckinAfterPolicyApprovesRetry(t *testing.T) {
+	server := mcstest.NewServer(failLogins(1, 401, "bad token"))
+	defer server.Close()
+	policy := &recordingPolicy{server: server, delay: 50 * time.Millisecond}
+	fcmClient := client.NewClient("1234", "5678", nil,
+		append(server.ClientOptions(), client.WithReconnectPolicy(policy))...)
+	defer fcmClient.Close()
+	recorder := recordStates(fcmClient)
+
+	result := runInBackground(testContext(t), fcmClient)
+	waitState(t, fcmClient, client.StateConnected)
+
+	// The policy decides on the rejection before the client checks in again
+	errs, checkins := policy.seen()
+	if len(errs) != 1 || !errors.Is(errs[0], client.ErrAuthRejected) {
+		t.Fatalf("policy errors = %v, want one ErrAuthRejected", errs)
+	}
+	if checkins[0] != 1 {
+		t.Errorf("check-ins when the policy decided = %d, want 1", checkins[0])
+	}
+	if got := server.Checkins(); got != 2 {
+		t.Errorf("check-ins = %d, want 2", got)
+	}
+
+	// ... and only after the backoff
+	want := [][2]client.State{
+		{client.StateLoggingIn, client.StateBackoff},
+		{client.StateBackoff, client.StateCheckingIn},
+		{client.StateCheckingIn, client.StateDialing},
+	}
+	eventually(t, "the reconnect transitions", func() bool {
+		return containsSequence(recorder.get(), want)
+	})
+
+	fcmClient.Close()
+	waitResult(t, result)
+}
+
+// containsSequence reports whether want appears in transitions as a contiguous run
+func containsSequence(transitions, want [][2]client.State) bool {
+	for i := 0; i+len(want) <= len(transitions); i++ {
+		if reflect.DeepEqual(transitions[i:i+len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
+
+// failingTransport fails the requests whose number is in fail and passes the rest on
+type failingTransport struct {
+	next http.RoundTripper
+	fail map[int32]bool
+	n    atomic.Int32
+}
+
+func (f *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if f.fail[f.n.Add(1)] {
+		return nil, errors.New("network down")
+	}
+	return f.next.RoundTrip(req)
+}
+
+func TestFailedCheckinIsReportedToPolicy(t *testing.T) {
+	server := mcstest.NewServer(failLogins(1, 401, "bad token"))
+	defer server.Close()
+	policy := &recordingPolicy{server: server}
+
+	// The check-in before the first reconnect fails, the next one succeeds
+	transport := &failingTransport{next: server.CheckinClient().Transport, fail: map[int32]bool{2: true}}
+	fcmClient := client.NewClient("1234", "5678", nil, append(server.ClientOptions(),
+		client.WithHTTPClient(&http.Client{Transport: transport}),
+		client.WithReconnectPolicy(policy),
+	)...)
+	defer fcmClient.Close()
+
+	result := runInBackground(testContext(t), fcmClient)
+	waitState(t, fcmClient, client.StateConnected)
+
+	errs, _ := policy.seen()
+	if len(errs) != 2 {
+		t.Fatalf("policy errors = %v, want 2", errs)
+	}
+	if !errors.Is(errs[0], client.ErrAuthRejected) {
+		t.Errorf("first policy error = %v, want ErrAuthRejected", errs[0])
+	}
+	if !errors.Is(errs[1], client.ErrCheckinFailed) || errors.Is(errs[1], client.ErrAuthRejected) {
+		t.Errorf("second policy error = %v, want ErrCheckinFailed only", errs[1])
+	}
+	// No login was attempted without a successful check-in
+	if logins := len(server.LoginRequests()); logins != 2 {
+		t.Errorf("logins = %d, want 2", logins)
+	}
+
+	fcmClient.Close()
+	waitResult(t, result)
+}
+
+func TestCircuitBreakerIgnoresCheckinFailures(t *testing.T) {
+	policy := &client.CircuitBreaker{
+		Policy:       &client.ExponentialBackoff{Initial: time.Millisecond},
+		AuthFailures: 2,
+	}
+	rejected := &client.LoginError{Code: 401}
+	checkinFailed := fmt.Errorf("%w: network down", client.ErrCheckinFailed)
+
+	if _, ok := policy.Next(1, 0, rejected); !ok {
+		t.Fatal("breaker tripped after one rejection")
+	}
+	if _, ok := policy.Next(2, 0, checkinFailed); !ok {
+		t.Fatal("breaker tripped on a failed check-in")
+	}
+	// The failed check-in did not reset the count
+	if _, ok := policy.Next(3, 0, rejected); ok {
+		t.Error("breaker did not trip after the second rejection")
+	}
+}
+
+func TestDefaultReconnectPolicyGivesUpAfterAuthFailures(t *testing.T) {
+	policy := client.DefaultReconnectPolicy()
+	rejected := &client.LoginError{Code: 401}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		delay, ok := policy.Next(attempt, 0, rejected)
+		if !ok || delay > time.Minute {
+			t.Fatalf("attempt %d: Next = %v, %v, want a backoff delay", attempt, delay, ok)
+		}
+	}
+
+	if delay, ok := policy.Next(3, 0, rejected); ok {
+		t.Errorf("tripped Next = %v, %v, want to give up", delay, ok)
+	}
+
+	policy.Reset()
+	if delay, ok := policy.Next(1, 0, rejected); !ok || delay > time.Second {
+		t.Errorf("Next after Reset = %v, %v, want a short backoff", delay, ok)
+	}
+}
+
+func TestCircuitBreakerCooldown(t *testing.T) {
+	policy := &client.CircuitBreaker{
+		Policy:       &client.ExponentialBackoff{Initial: time.Millisecond},
+		AuthFailures: 1,
+		Cooldown:     15 * time.Minute,
+	}
+
+	if delay, ok := policy.Next(1, 0, &client.LoginError{Code: 401}); !ok || delay != 15*time.Minute {
+		t.Errorf("tripped Next = %v, %v, want 15m, true", delay, ok)
+	}
+}
+
+func TestDefaultReconnectPolicyStopsOnPermanentRejection(t *testing.T) {
+	server, fcmClient := newTestClient(t, []mcstest.Option{failLogins(100, 401, "bad token")})
+
+	// The default backoff waits at most one and then two seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 2*testTimeout)
+	defer cancel()
+	result := runInBackground(ctx, fcmClient)
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, client.ErrAuthRejected) {
+			t.Fatalf("Run error = %v, want ErrAuthRejected", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Run did not give up on rejected credentials")
+	}
+	if logins := len(server.LoginRequests()); logins != 3 {
+		t.Errorf("logins = %d, want 3", logins)
+	}
+}
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// spillFile is an on-disk FIFO of events that did not fit in the event buffer.
+// Spilled messages are not acknowledged until delivered, so the file is
+// scratch space only and is removed when the client stops.
+type spillFile struct {
+	path   string
+	writer *os.File
+	reader *os.File
+	buf    *bufio.Reader
+	// ids holds the persistent ID of every event in the file, in order, so messages
+	// can still be forgotten when their record cannot be read back
+	ids []string
+}
+
+// spilledEvent is the on-disk form of an event
+type spilledEvent struct {
+	Type         EventType       `json:"type"`
+	PersistentID string          `json:"persistentId,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
+	// Err holds the message of a DisconnectInfo, ReconnectInfo or ErrorInfo error
+	Err string `json:"err,omitempty"`
+	// LoginErr and StreamErr keep a typed error so errors.Is still works after spilling
+	LoginErr  *LoginError  `json:"loginErr,omitempty"`
+	StreamErr *StreamError `json:"streamErr,omitempty"`
+}
+
+// restoredError is a spilled error read back from disk. It keeps the original
+// message and unwraps to the typed error it carried, if any.
+type restoredError struct {
+	msg   string
+	cause error
+}
+
+func (e *restoredError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the typed error the spilled error wrapped
+func (e *restoredError) Unwrap() error {
+	return e.cause
+}
+
+// openSpillFile creates a new spill file in dir
+func openSpillFile(dir string) (*spillFile, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	writer, err := os.CreateTemp(dir, "push-receiver-events-*.spill")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill file: %w", err)
+	}
+
+	reader, err := os.Open(writer.Name())
+	if err != nil {
+		writer.Close()
+		os.Remove(writer.Name())
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+
+	return &spillFile{
+		path:   writer.Name(),
+		writer: writer,
+		reader: reader,
+		buf:    bufio.NewReader(reader),
+	}, nil
+}
+
+// len returns how many events are waiting in the spill file
+func (s *spillFile) len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.ids)
+}
+
+// write appends an event to the spill file
+func (s *spillFile) write(ev queuedEvent) error {
+	record, err := encodeSpilledEvent(ev)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spilled event: %w", err)
+	}
+
+	if _, err := s.writer.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	s.ids = append(s.ids, ev.persistentID)
+	return nil
+}
+
+// read removes the oldest event from the spill file. On failure it also returns
+// the persistent IDs of the events that were lost.
+func (s *spillFile) read() (queuedEvent, []string, error) {
+	line, err := s.buf.ReadBytes('\n')
+	if err != nil {
+		// The file is unreadable from here on, start over
+		lost := s.ids
+		s.reset()
+		return queuedEvent{}, lost, fmt.Errorf("failed to read spill file: %w", err)
+	}
+
+	persistentID := s.ids[0]
+	s.ids = s.ids[1:]
+	if len(s.ids) == 0 {
+		s.reset()
+	}
+
+	var record spilledEvent
+	if err := json.Unmarshal(line, &record); err != nil {
+		return queuedEvent{}, []string{persistentID}, fmt.Errorf("failed to unmarshal spilled event: %w", err)
+	}
+	ev, err := decodeSpilledEvent(record)
+	if err != nil {
+		return queuedEvent{}, []string{persistentID}, err
+	}
+	return ev, nil, nil
+}
+
+// reset truncates the spill file once it has been drained
+func (s *spillFile) reset() {
+	s.ids = nil
+	s.writer.Truncate(0)
+	s.writer.Seek(0, io.SeekStart)
+	s.reader.Seek(0, io.SeekStart)
+	s.buf.Reset(s.reader)
+}
+
+// close closes and removes the spill file
+func (s *spillFile) close() {
+	s.writer.Close()
+	s.reader.Close()
+	os.Remove(s.path)
+}
+
+// encodeSpilledEvent converts an event to its on-disk form
+func encodeSpilledEvent(ev queuedEvent) (spilledEvent, error) {
+	record := spilledEvent{Type: ev.Type, PersistentID: ev.persistentID}
+
+	var data interface{} = ev.Data
+	switch info := ev.Data.(type) {
+	case *DisconnectInfo:
+		data = &DisconnectInfo{Time: info.Time}
+		record.setErr(info.Err)
+	case *ErrorInfo:
+		data = &ErrorInfo{Time: info.Time}
+		record.setErr(info.Err)
+	case *ReconnectInfo:
+		copied := *info
+		copied.Err = nil
+		data = &copied
+		record.setErr(info.Err)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return spilledEvent{}, fmt.Errorf("failed to marshal %s event: %w", ev.Type, err)
+	}
+	record.Data = raw
+	return record, nil
+}
+
+// setErr stores err, keeping a LoginError or StreamError it wraps
+func (r *spilledEvent) setErr(err error) {
+	if err == nil {
+		return
+	}
+	r.Err = err.Error()
+
+	var loginErr *LoginError
+	var streamErr *StreamError
+	switch {
+	case errors.As(err, &loginErr):
+		r.LoginErr = loginErr
+	case errors.As(err, &streamErr):
+		r.StreamErr = streamErr
+	}
+}
+
+// restoreErr returns the stored error, or nil if there is none
+func (r *spilledEvent) restoreErr() error {
+	switch {
+	case r.LoginErr != nil:
+		return &restoredError{msg: r.Err, cause: r.LoginErr}
+	case r.StreamErr != nil:
+		return &restoredError{msg: r.Err, cause: r.StreamErr}
+	case r.Err != "":
+		return errors.New(r.Err)
+	}
+	return nil
+}
+
+// decodeSpilledEvent restores an event from its on-disk form.
+// Errors come back carrying the original message; a LoginError or StreamError
+// is kept, so errors.Is and errors.As work as before spilling.
+func decodeSpilledEvent(record spilledEvent) (queuedEvent, error) {
+	err := record.restoreErr()
+
+	var data interface{}
+	switch record.Type {
+	case EventConnect:
+		data = &ConnectInfo{}
+	case EventDisconnect:
+		data = &DisconnectInfo{}
+	case EventDataReceived:
+		data = &DataMessage{}
+	case EventNotificationReceived:
+		data = &Notification{}
+	case EventError, EventCheckinFailed:
+		data = &ErrorInfo{}
+	case EventReconnecting:
+		data = &ReconnectInfo{}
+	case EventStateChange:
+		data = &StateChange{}
+	default:
+		data = &time.Time{}
+	}
+
+	if len(record.Data) > 0 {
+		if jsonErr := json.Unmarshal(record.Data, data); jsonErr != nil {
+			return queuedEvent{}, fmt.Errorf("failed to unmarshal %s event: %w", record.Type, jsonErr)
+		}
+	}
+
+	switch info := data.(type) {
+	case *DisconnectInfo:
+		info.Err = err
+	case *ErrorInfo:
+		info.Err = err
+	case *ReconnectInfo:
+		info.Err = err
+	case *time.Time:
+		data = *info
+	}
+
+	return queuedEvent{
+		Event:        Event{Type: record.Type, Data: data},
+		persistentID: record.PersistentID,
+	}, nil
+}
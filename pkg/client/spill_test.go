@@ -0,0 +1,101 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSpilledErrorsKeepTheirType(t *testing.T) {
+	streamErr := &StreamError{Type: streamErrorNotAuthorized, Text: "bad token"}
+	ev := queuedEvent{Event: Event{Type: EventDisconnect, Data: &DisconnectInfo{
+		Time: time.Now(),
+		Err:  fmt.Errorf("connection lost: %w", streamErr),
+	}}}
+
+	record, err := encodeSpilledEvent(ev)
+	if err != nil {
+		t.Fatalf("encodeSpilledEvent: %v", err)
+	}
+	decoded, err := decodeSpilledEvent(record)
+	if err != nil {
+		t.Fatalf("decodeSpilledEvent: %v", err)
+	}
+
+	info, ok := decoded.DisconnectInfo()
+	if !ok {
+		t.Fatalf("decoded data = %T, want *DisconnectInfo", decoded.Data)
+	}
+	if info.Err.Error() != "connection lost: stream error: not-authorized: bad token" {
+		t.Errorf("error message = %q", info.Err.Error())
+	}
+	if !errors.Is(info.Err, ErrAuthRejected) || !errors.Is(info.Err, ErrStreamError) {
+		t.Errorf("decoded error %v no longer matches ErrAuthRejected and ErrStreamError", info.Err)
+	}
+
+	var restored *StreamError
+	if !errors.As(info.Err, &restored) || *restored != *streamErr {
+		t.Errorf("errors.As = %+v, want %+v", restored, streamErr)
+	}
+}
+
+func TestSpillFileReportsLostEvents(t *testing.T) {
+	spill, err := openSpillFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("openSpillFile: %v", err)
+	}
+	defer spill.close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		ev := queuedEvent{Event: Event{Type: EventDataReceived, Data: &DataMessage{PersistentID: id}}, persistentID: id}
+		if err := spill.write(ev); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	ev, lost, err := spill.read()
+	if err != nil || ev.persistentID != "a" || lost != nil {
+		t.Fatalf("read = %q, %v, %v, want a", ev.persistentID, lost, err)
+	}
+
+	// Losing the rest of the file must report every remaining message
+	if err := os.Truncate(spill.path, 0); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+	spill.buf.Reset(spill.reader)
+
+	_, lost, err = spill.read()
+	if err == nil {
+		t.Fatal("read succeeded on a truncated file")
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(lost, want) {
+		t.Errorf("lost = %v, want %v", lost, want)
+	}
+	if spill.len() != 0 {
+		t.Errorf("len = %d after losing the file, want 0", spill.len())
+	}
+}
+
+func TestSpillFileReportsUndecodableEvent(t *testing.T) {
+	spill, err := openSpillFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("openSpillFile: %v", err)
+	}
+	defer spill.close()
+
+	if _, err := spill.writer.WriteString("not json\n"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+	spill.ids = append(spill.ids, "broken")
+
+	_, lost, err := spill.read()
+	if err == nil {
+		t.Fatal("read decoded a corrupt record")
+	}
+	if want := []string{"broken"}; !reflect.DeepEqual(lost, want) {
+		t.Errorf("lost = %v, want %v", lost, want)
+	}
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// State is where the client is in its connection lifecycle
+type State int
+
+const (
+	// StateIdle is a client that has not connected yet
+	StateIdle State = iota
+	// StateCheckingIn is performing the GCM check-in
+	StateCheckingIn
+	// StateDialing is opening the TLS connection to MCS
+	StateDialing
+	// StateLoggingIn has sent the LoginRequest and waits for the LoginResponse
+	StateLoggingIn
+	// StateConnected is logged in and receiving messages
+	StateConnected
+	// StateBackoff has lost its connection and waits to reconnect or give up
+	StateBackoff
+	// StateClosed has been closed and will not connect again
+	StateClosed
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "Idle"
+	case StateCheckingIn:
+		return "CheckingIn"
+	case StateDialing:
+		return "Dialing"
+	case StateLoggingIn:
+		return "LoggingIn"
+	case StateConnected:
+		return "Connected"
+	case StateBackoff:
+		return "Backoff"
+	case StateClosed:
+		return "Closed"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// StateChange is the payload of EventStateChange
+type StateChange struct {
+	// Time is when the transition happened
+	Time time.Time
+	From State
+	To   State
+}
+
+// StateChange returns the payload of an EventStateChange event
+func (e Event) StateChange() (*StateChange, bool) {
+	change, ok := e.Data.(*StateChange)
+	return change, ok
+}
+
+// stateNode is one entry in the list of states the client went through.
+// done is closed once next is set, so waiters can follow every transition.
+type stateNode struct {
+	state State
+	done  chan struct{}
+	next  *stateNode
+}
+
+// newStateNode creates the node for a newly entered state
+func newStateNode(state State) *stateNode {
+	return &stateNode{state: state, done: make(chan struct{})}
+}
+
+// State returns the current connection state
+func (c *Client) State() State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state.state
+}
+
+// Wait blocks until the client enters state. It also returns for a state that is
+// entered and left again while waiting. It returns ErrClientClosed if the client is
+// closed first, or ctx.Err() when ctx is done.
+func (c *Client) Wait(ctx context.Context, state State) error {
+	c.mu.RLock()
+	node := c.state
+	c.mu.RUnlock()
+
+	for {
+		if node.state == state {
+			return nil
+		}
+		if node.state == StateClosed {
+			return ErrClientClosed
+		}
+
+		select {
+		case <-node.done:
+			node = node.next
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setState moves the client to state and emits EventStateChange
+func (c *Client) setState(state State) {
+	c.mu.Lock()
+	change := c.setStateLocked(state)
+	c.mu.Unlock()
+
+	if change != nil {
+		c.sendEvent(Event{Type: EventStateChange, Data: change})
+	}
+}
+
+// setStateLocked moves the client to state with c.mu held. It returns the change
+// to emit once the lock is released, or nil if nothing changed.
+// Closed is final, nothing leaves it.
+func (c *Client) setStateLocked(state State) *StateChange {
+	from := c.state.state
+	if from == state || from == StateClosed {
+		return nil
+	}
+
+	next := newStateNode(state)
+	c.state.next = next
+	close(c.state.done)
+	c.state = next
+
+	c.debugLog("State %s -> %s", from, state)
+	return &StateChange{Time: time.Now(), From: from, To: state}
+}
@@ -0,0 +1,170 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+)
+
+// fixedDelay reconnects after the same delay every time, or gives up when giveUp is set
+type fixedDelay struct {
+	delay  time.Duration
+	giveUp bool
+}
+
+func (p fixedDelay) Next(attempt int, elapsed time.Duration, err error) (time.Duration, bool) {
+	return p.delay, !p.giveUp
+}
+
+func (p fixedDelay) Reset() {}
+
+// stateRecorder reads every event from the client and keeps the state transitions
+type stateRecorder struct {
+	mu          sync.Mutex
+	transitions [][2]client.State
+}
+
+// recordStates starts reading events from fcmClient
+func recordStates(fcmClient *client.Client) *stateRecorder {
+	r := &stateRecorder{}
+	go func() {
+		for event := range fcmClient.Events() {
+			if change, ok := event.StateChange(); ok {
+				r.mu.Lock()
+				r.transitions = append(r.transitions, [2]client.State{change.From, change.To})
+				r.mu.Unlock()
+			}
+		}
+	}()
+	return r
+}
+
+// get returns the transitions recorded so far
+func (r *stateRecorder) get() [][2]client.State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][2]client.State(nil), r.transitions...)
+}
+
+// waitState waits until fcmClient enters state
+func waitState(t *testing.T, fcmClient *client.Client, state client.State) {
+	t.Helper()
+	if err := fcmClient.Wait(testContext(t), state); err != nil {
+		t.Fatalf("Wait(%s): %v", state, err)
+	}
+}
+
+func TestStateTransitions(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil, client.WithReconnectPolicy(fixedDelay{delay: 200 * time.Millisecond}))
+	recorder := recordStates(fcmClient)
+
+	if state := fcmClient.State(); state != client.StateIdle {
+		t.Fatalf("initial state = %s, want Idle", state)
+	}
+
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	waitState(t, fcmClient, client.StateConnected)
+
+	// A dropped connection goes through Backoff and back to Connected
+	waitConn(t, server).Close()
+	waitState(t, fcmClient, client.StateBackoff)
+	waitState(t, fcmClient, client.StateConnected)
+
+	fcmClient.Close()
+	if state := fcmClient.State(); state != client.StateClosed {
+		t.Errorf("state after Close = %s, want Closed", state)
+	}
+
+	want := [][2]client.State{
+		{client.StateIdle, client.StateCheckingIn},
+		{client.StateCheckingIn, client.StateDialing},
+		{client.StateDialing, client.StateLoggingIn},
+		{client.StateLoggingIn, client.StateConnected},
+		{client.StateConnected, client.StateBackoff},
+		{client.StateBackoff, client.StateDialing},
+		{client.StateDialing, client.StateLoggingIn},
+		{client.StateLoggingIn, client.StateConnected},
+		{client.StateConnected, client.StateClosed},
+	}
+	eventually(t, "every state change event", func() bool {
+		return len(recorder.get()) >= len(want)
+	})
+	if got := recorder.get(); !reflect.DeepEqual(got, want) {
+		t.Errorf("transitions = %v, want %v", got, want)
+	}
+}
+
+func TestWaitAfterClose(t *testing.T) {
+	_, fcmClient := newTestClient(t, nil)
+	recordStates(fcmClient)
+
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	waitState(t, fcmClient, client.StateConnected)
+
+	// A waiter for a state that never comes is released by Close
+	waiting := make(chan error, 1)
+	go func() {
+		waiting <- fcmClient.Wait(context.Background(), client.StateBackoff)
+	}()
+
+	fcmClient.Close()
+	select {
+	case err := <-waiting:
+		if !errors.Is(err, client.ErrClientClosed) {
+			t.Errorf("Wait(Backoff) = %v, want ErrClientClosed", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Wait did not return after Close")
+	}
+
+	waitState(t, fcmClient, client.StateClosed)
+	if err := fcmClient.Wait(testContext(t), client.StateConnected); !errors.Is(err, client.ErrClientClosed) {
+		t.Errorf("Wait(Connected) after Close = %v, want ErrClientClosed", err)
+	}
+}
+
+func TestWaitHonoursContext(t *testing.T) {
+	_, fcmClient := newTestClient(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := fcmClient.Wait(ctx, client.StateConnected); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestStateLeavesConnectedWhenPolicyGivesUp(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil, client.WithReconnectPolicy(fixedDelay{giveUp: true}))
+	recorder := recordStates(fcmClient)
+
+	result := runInBackground(testContext(t), fcmClient)
+	waitState(t, fcmClient, client.StateConnected)
+
+	waitConn(t, server).Close()
+	if err := waitResult(t, result); err == nil {
+		t.Error("Run returned nil after the policy gave up, want the connection error")
+	}
+
+	eventually(t, "the Closed transition", func() bool {
+		transitions := recorder.get()
+		return len(transitions) > 0 && transitions[len(transitions)-1][1] == client.StateClosed
+	})
+	transitions := recorder.get()
+	tail := transitions[len(transitions)-2:]
+	want := [][2]client.State{
+		{client.StateConnected, client.StateBackoff},
+		{client.StateBackoff, client.StateClosed},
+	}
+	if !reflect.DeepEqual(tail, want) {
+		t.Errorf("last transitions = %v, want %v", tail, want)
+	}
+}
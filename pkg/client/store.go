@@ -0,0 +1,193 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/palbooo/push-receiver-go/internal/utils"
+)
+
+// PersistentIDStore keeps the persistent IDs of received messages until the server
+// confirms them, so a restarted client does not get the same messages again
+type PersistentIDStore interface {
+	// Load returns the stored persistent IDs
+	Load() ([]string, error)
+	// Add stores persistent IDs of messages that were received
+	Add(ids ...string) error
+	// Remove drops persistent IDs the server has confirmed
+	Remove(ids ...string) error
+}
+
+// WithPersistentIDStore sets where persistent IDs are kept between connections and restarts.
+// The IDs passed to NewClient are used in addition to the stored ones.
+func WithPersistentIDStore(store PersistentIDStore) ClientOption {
+	return func(c *Client) {
+		if store != nil {
+			c.idStore = store
+		}
+	}
+}
+
+// idSet is an insertion-ordered set of persistent IDs
+type idSet struct {
+	ids   []string
+	index map[string]struct{}
+}
+
+// add inserts ids, reporting whether anything changed
+func (s *idSet) add(ids ...string) bool {
+	if s.index == nil {
+		s.index = make(map[string]struct{})
+	}
+
+	changed := false
+	for _, id := range ids {
+		if _, ok := s.index[id]; ok || id == "" {
+			continue
+		}
+		s.index[id] = struct{}{}
+		s.ids = append(s.ids, id)
+		changed = true
+	}
+	return changed
+}
+
+// remove deletes ids, reporting whether anything changed
+func (s *idSet) remove(ids ...string) bool {
+	changed := false
+	for _, id := range ids {
+		if _, ok := s.index[id]; ok {
+			delete(s.index, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return false
+	}
+
+	kept := s.ids[:0]
+	for _, id := range s.ids {
+		if _, ok := s.index[id]; ok {
+			kept = append(kept, id)
+		}
+	}
+	s.ids = kept
+	return true
+}
+
+// list returns a copy of the IDs in insertion order
+func (s *idSet) list() []string {
+	return append([]string{}, s.ids...)
+}
+
+// MemoryPersistentIDStore keeps persistent IDs in memory only
+type MemoryPersistentIDStore struct {
+	mu  sync.Mutex
+	set idSet
+}
+
+// NewMemoryPersistentIDStore creates an in-memory store holding ids
+func NewMemoryPersistentIDStore(ids ...string) *MemoryPersistentIDStore {
+	s := &MemoryPersistentIDStore{}
+	s.set.add(ids...)
+	return s
+}
+
+// Load returns the stored persistent IDs
+func (s *MemoryPersistentIDStore) Load() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set.list(), nil
+}
+
+// Add stores persistent IDs
+func (s *MemoryPersistentIDStore) Add(ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set.add(ids...)
+	return nil
+}
+
+// Remove drops persistent IDs
+func (s *MemoryPersistentIDStore) Remove(ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set.remove(ids...)
+	return nil
+}
+
+// FilePersistentIDStore keeps persistent IDs in a JSON file.
+// Every change is written to a temporary file, synced and renamed over the
+// old one, so a crash leaves either the old or the new set on disk.
+type FilePersistentIDStore struct {
+	mu   sync.Mutex
+	path string
+	set  idSet
+}
+
+// NewFilePersistentIDStore opens the store at path, creating it on the first write
+func NewFilePersistentIDStore(path string) (*FilePersistentIDStore, error) {
+	s := &FilePersistentIDStore{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read persistent IDs: %w", err)
+	}
+
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("failed to parse persistent IDs: %w", err)
+	}
+	s.set.add(ids...)
+
+	return s, nil
+}
+
+// Load returns the stored persistent IDs
+func (s *FilePersistentIDStore) Load() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set.list(), nil
+}
+
+// Add stores persistent IDs and writes them to disk
+func (s *FilePersistentIDStore) Add(ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.set.add(ids...) {
+		return nil
+	}
+	return s.save()
+}
+
+// Remove drops persistent IDs and writes the rest to disk
+func (s *FilePersistentIDStore) Remove(ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.set.remove(ids...) {
+		return nil
+	}
+	return s.save()
+}
+
+// save atomically replaces the file with the current set
+func (s *FilePersistentIDStore) save() error {
+	data, err := json.Marshal(s.set.list())
+	if err != nil {
+		return fmt.Errorf("failed to marshal persistent IDs: %w", err)
+	}
+
+	if err := utils.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save persistent IDs: %w", err)
+	}
+	return nil
+}
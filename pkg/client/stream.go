@@ -0,0 +1,129 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/palbooo/push-receiver-go/internal/constants"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// streamState tracks RMQ2 stream IDs for a single MCS connection.
+// Each side counts the messages it has sent and received since login, and
+// reports the last stream ID it received so the other side can drop
+// everything up to that point.
+type streamState struct {
+	mu sync.Mutex
+	// Stream ID of the last message received from the server
+	inID int32
+	// Stream ID of the last message sent to the server
+	outID int32
+	// Last inID reported to the server in an outgoing message
+	lastReported int32
+	// Persistent IDs sent in a SelectiveAck, keyed by the stream ID of that ack
+	unconfirmed map[int32][]string
+}
+
+// newStreamState creates the stream state for a connection whose LoginRequest has been sent
+func newStreamState() *streamState {
+	return &streamState{
+		outID:       1,
+		unconfirmed: make(map[int32][]string),
+	}
+}
+
+// lastStreamIDReceiver is implemented by every stanza that carries last_stream_id_received
+type lastStreamIDReceiver interface {
+	GetLastStreamIdReceived() int32
+}
+
+// received records an incoming message and returns the persistent IDs whose
+// SelectiveAck the server has now confirmed
+func (s *streamState) received(msg proto.Message) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inID++
+
+	r, ok := msg.(lastStreamIDReceiver)
+	if !ok || r.GetLastStreamIdReceived() == 0 {
+		return nil
+	}
+
+	var confirmed []string
+	last := r.GetLastStreamIdReceived()
+	for streamID, ids := range s.unconfirmed {
+		if streamID <= last {
+			confirmed = append(confirmed, ids...)
+			delete(s.unconfirmed, streamID)
+		}
+	}
+	return confirmed
+}
+
+// sent records an outgoing message and returns its stream ID together with
+// the last stream ID received, which the message must report
+func (s *streamState) sent() (int32, int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outID++
+	s.lastReported = s.inID
+	return s.outID, s.inID
+}
+
+// unacked returns how many received messages have not been reported to the server
+func (s *streamState) unacked() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int(s.inID - s.lastReported)
+}
+
+// awaitConfirmation remembers persistent IDs acknowledged by the message with the given stream ID
+func (s *streamState) awaitConfirmation(streamID int32, ids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unconfirmed[streamID] = ids
+}
+
+// setLastStreamIDReceived fills in last_stream_id_received on an outgoing stanza
+func setLastStreamIDReceived(msg proto.Message, id int32) {
+	switch m := msg.(type) {
+	case *pb.HeartbeatPing:
+		m.LastStreamIdReceived = proto.Int32(id)
+	case *pb.HeartbeatAck:
+		m.LastStreamIdReceived = proto.Int32(id)
+	case *pb.IqStanza:
+		m.LastStreamIdReceived = proto.Int32(id)
+	case *pb.DataMessageStanza:
+		m.LastStreamIdReceived = proto.Int32(id)
+	}
+}
+
+// buildStreamAck creates an IqStanza carrying a StreamAck extension
+func buildStreamAck() (*pb.IqStanza, error) {
+	return buildAckIq(constants.StreamAckExtension, &pb.StreamAck{})
+}
+
+// buildSelectiveAck creates an IqStanza carrying a SelectiveAck for the given persistent IDs
+func buildSelectiveAck(ids []string) (*pb.IqStanza, error) {
+	return buildAckIq(constants.SelectiveAckExtension, &pb.SelectiveAck{Id: ids})
+}
+
+func buildAckIq(extensionID int32, ext proto.Message) (*pb.IqStanza, error) {
+	data, err := proto.Marshal(ext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.IqStanza{
+		Type: pb.IqStanza_SET.Enum(),
+		Id:   proto.String(""),
+		Extension: &pb.Extension{
+			Id:   proto.Int32(extensionID),
+			Data: data,
+		},
+	}, nil
+}
@@ -0,0 +1,109 @@
+package client_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/palbooo/push-receiver-go/internal/constants"
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+	pb "github.com/palbooo/push-receiver-go/proto"
+)
+
+// ackIqs returns the IqStanzas with the given extension the server received
+func ackIqs(server *mcstest.Server, extensionID int32) []*pb.IqStanza {
+	var iqs []*pb.IqStanza
+	for _, frame := range server.Frames() {
+		if iq, ok := frame.Message.(*pb.IqStanza); ok && iq.GetExtension().GetId() == extensionID {
+			iqs = append(iqs, iq)
+		}
+	}
+	return iqs
+}
+
+func TestStreamAckEveryNMessages(t *testing.T) {
+	// Nobody reads the events, so no SelectiveAck reports the stream position first
+	server, fcmClient := newTestClient(t, nil, client.WithStreamAckInterval(3))
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	conn := waitConn(t, server)
+
+	// The LoginResponse and two data messages make three
+	sendData(t, conn, "a")
+	sendData(t, conn, "b")
+	eventually(t, "the first StreamAck", func() bool {
+		return len(ackIqs(server, constants.StreamAckExtension)) == 1
+	})
+
+	sendData(t, conn, "c")
+	sendData(t, conn, "d")
+	sendData(t, conn, "e")
+	eventually(t, "the second StreamAck", func() bool {
+		return len(ackIqs(server, constants.StreamAckExtension)) == 2
+	})
+
+	var got []int32
+	for _, iq := range ackIqs(server, constants.StreamAckExtension) {
+		if iq.GetType() != pb.IqStanza_SET {
+			t.Errorf("StreamAck type = %v, want SET", iq.GetType())
+		}
+		got = append(got, iq.GetLastStreamIdReceived())
+	}
+	if want := []int32{3, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamAck last_stream_id_received = %v, want %v", got, want)
+	}
+}
+
+func TestSelectiveAckReportsStreamPosition(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil)
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	conn := waitConn(t, server)
+
+	sendData(t, conn, "a")
+	if id := nextDataMessage(t, fcmClient); id != "a" {
+		t.Fatalf("received %q, want a", id)
+	}
+
+	eventually(t, "a SelectiveAck", func() bool {
+		return len(ackIqs(server, constants.SelectiveAckExtension)) == 1
+	})
+	ack := ackIqs(server, constants.SelectiveAckExtension)[0]
+	// The LoginResponse and the data message
+	if last := ack.GetLastStreamIdReceived(); last != 2 {
+		t.Errorf("SelectiveAck last_stream_id_received = %d, want 2", last)
+	}
+}
+
+func TestUnconfirmedSelectiveAckIsReportedAtLogin(t *testing.T) {
+	server, fcmClient := newTestClient(t, nil, client.WithReconnectPolicy(fixedDelay{}))
+	if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+		t.Fatalf("ConnectContext: %v", err)
+	}
+	conn := waitConn(t, server)
+
+	sendData(t, conn, "a")
+	if id := nextDataMessage(t, fcmClient); id != "a" {
+		t.Fatalf("received %q, want a", id)
+	}
+	eventually(t, "the SelectiveAck for a", func() bool {
+		return len(server.SelectiveAcks()) == 1
+	})
+
+	// The next stanza confirms the ack for a, the one for b is never confirmed
+	sendData(t, conn, "b")
+	if id := nextDataMessage(t, fcmClient); id != "b" {
+		t.Fatalf("received %q, want b", id)
+	}
+	eventually(t, "the SelectiveAck for b", func() bool {
+		return len(server.SelectiveAcks()) == 2
+	})
+
+	conn.Close()
+	relogin := waitConn(t, server).LoginRequest()
+	if got, want := relogin.GetReceivedPersistentId(), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReceivedPersistentId at re-login = %v, want %v", got, want)
+	}
+}
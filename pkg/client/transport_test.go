@@ -0,0 +1,115 @@
+package client_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+)
+
+// connectProxy is an HTTP CONNECT proxy that records the addresses it tunnels to
+type connectProxy struct {
+	url *url.URL
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// startConnectProxy serves a CONNECT proxy until the test ends
+func startConnectProxy(t *testing.T) *connectProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	p := &connectProxy{url: &url.URL{Scheme: "http", Host: listener.Addr().String()}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p
+}
+
+func (p *connectProxy) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil || req.Method != http.MethodConnect {
+		return
+	}
+
+	p.mu.Lock()
+	p.targets = append(p.targets, req.Host)
+	p.mu.Unlock()
+
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	go io.Copy(target, reader)
+	io.Copy(conn, target)
+}
+
+// requested returns the CONNECT targets the proxy has seen
+func (p *connectProxy) requested() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func TestWithHTTPClientOverridesProxyInAnyOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order func(proxyOpt client.ClientOption, serverOpts []client.ClientOption) []client.ClientOption
+	}{
+		{"proxy first", func(proxyOpt client.ClientOption, serverOpts []client.ClientOption) []client.ClientOption {
+			return append([]client.ClientOption{proxyOpt}, serverOpts...)
+		}},
+		{"proxy last", func(proxyOpt client.ClientOption, serverOpts []client.ClientOption) []client.ClientOption {
+			return append(serverOpts, proxyOpt)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := mcstest.NewServer()
+			defer server.Close()
+			proxy := startConnectProxy(t)
+
+			// The server's HTTP client trusts its certificate, a client derived
+			// from the proxy would fail the check-in
+			opts := tt.order(client.WithProxy(proxy.url), server.ClientOptions())
+			fcmClient := client.NewClient("1234", "5678", nil, opts...)
+			defer fcmClient.Close()
+
+			if err := fcmClient.ConnectContext(testContext(t)); err != nil {
+				t.Fatalf("ConnectContext: %v", err)
+			}
+			if server.Checkins() != 1 {
+				t.Errorf("check-ins = %d, want 1", server.Checkins())
+			}
+			// Only the MCS connection goes through the proxy
+			if got, want := proxy.requested(), []string{server.Addr()}; !reflect.DeepEqual(got, want) {
+				t.Errorf("proxied connections = %v, want %v", got, want)
+			}
+		})
+	}
+}
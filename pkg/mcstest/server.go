@@ -0,0 +1,497 @@
+// Package mcstest provides an in-process fake MCS server for testing code built on client.Client.
+//
+// Example:
+//
+//	server := mcstest.NewServer()
+//	defer server.Close()
+//
+//	fcmClient := client.NewClient("1234", "5678", nil, server.ClientOptions()...)
+//	fcmClient.Connect()
+//
+//	conn, _ := server.WaitConn(ctx)
+//	conn.SendDataMessage(&pb.DataMessageStanza{...})
+package mcstest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/palbooo/push-receiver-go/internal/constants"
+	"github.com/palbooo/push-receiver-go/internal/parser"
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Frame is a message received from or sent to a client
+type Frame struct {
+	Tag     uint8
+	Message proto.Message
+}
+
+// LoginHandler builds the LoginResponse for a client's LoginRequest
+type LoginHandler func(req *pb.LoginRequest) *pb.LoginResponse
+
+// Server is a fake MCS server listening on a local TLS port.
+// It also serves a fake GCM check-in endpoint so clients can connect fully offline.
+type Server struct {
+	listener     net.Listener
+	checkin      *httptest.Server
+	loginHandler LoginHandler
+	autoAck      bool
+
+	mu       sync.Mutex
+	conns    []*Conn
+	frames   []Frame
+	checkins int
+	// loggedIn holds logged-in connections not yet returned by WaitConn,
+	// loginSignal is closed and replaced whenever one is added
+	loggedIn    []*Conn
+	loginSignal chan struct{}
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// Option configures the server
+type Option func(*Server)
+
+// WithLoginHandler sets the function that answers LoginRequests, e.g. to return
+// an error or a HeartbeatConfig
+func WithLoginHandler(handler LoginHandler) Option {
+	return func(s *Server) {
+		s.loginHandler = handler
+	}
+}
+
+// WithoutHeartbeatAck stops the server from answering client HeartbeatPings
+func WithoutHeartbeatAck() Option {
+	return func(s *Server) {
+		s.autoAck = false
+	}
+}
+
+// NewServer starts a fake MCS server and check-in endpoint on localhost
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		loginHandler: DefaultLoginResponse,
+		autoAck:      true,
+		loginSignal:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// The check-in server's certificate is valid for 127.0.0.1 and is reused for MCS
+	s.checkin = httptest.NewTLSServer(http.HandlerFunc(s.handleCheckin))
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("mcstest: failed to listen: %v", err))
+	}
+	s.listener = tls.NewListener(tcp, &tls.Config{
+		Certificates: s.checkin.TLS.Certificates,
+	})
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s
+}
+
+// DefaultLoginResponse accepts every login
+func DefaultLoginResponse(req *pb.LoginRequest) *pb.LoginResponse {
+	return &pb.LoginResponse{
+		Id: proto.String(req.GetId()),
+	}
+}
+
+// Addr returns the host:port the MCS server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// CheckinURL returns the URL of the fake GCM check-in endpoint
+func (s *Server) CheckinURL() string {
+	return s.checkin.URL + "/checkin"
+}
+
+// CheckinClient returns an HTTP client that trusts the check-in endpoint
+func (s *Server) CheckinClient() *http.Client {
+	return s.checkin.Client()
+}
+
+// TLSConfig returns a client TLS configuration that trusts the server's certificate
+func (s *Server) TLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.checkin.Certificate())
+	return &tls.Config{RootCAs: pool}
+}
+
+// ClientOptions returns the options that point a client.Client at this server
+func (s *Server) ClientOptions() []client.ClientOption {
+	host, port, _ := net.SplitHostPort(s.Addr())
+	return []client.ClientOption{
+		client.WithEndpoint(host, port),
+		client.WithTLSConfig(s.TLSConfig()),
+		client.WithCheckinURL(s.CheckinURL()),
+		client.WithHTTPClient(s.CheckinClient()),
+	}
+}
+
+// WaitConn returns the next client connection once it has logged in.
+// Connections are queued until they are waited for, so every login is returned in order.
+func (s *Server) WaitConn(ctx context.Context) (*Conn, error) {
+	for {
+		s.mu.Lock()
+		if len(s.loggedIn) > 0 {
+			conn := s.loggedIn[0]
+			s.loggedIn = s.loggedIn[1:]
+			s.mu.Unlock()
+			return conn, nil
+		}
+		signal := s.loginSignal
+		s.mu.Unlock()
+
+		select {
+		case <-signal:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Conns returns every connection accepted so far
+func (s *Server) Conns() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Conn(nil), s.conns...)
+}
+
+// Frames returns every frame received from any client, in order
+func (s *Server) Frames() []Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Frame(nil), s.frames...)
+}
+
+// LoginRequests returns every LoginRequest received
+func (s *Server) LoginRequests() []*pb.LoginRequest {
+	var reqs []*pb.LoginRequest
+	for _, frame := range s.Frames() {
+		if req, ok := frame.Message.(*pb.LoginRequest); ok {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+// SelectiveAcks returns every persistent ID acknowledged with a SelectiveAck
+func (s *Server) SelectiveAcks() []string {
+	var ids []string
+	for _, frame := range s.Frames() {
+		iq, ok := frame.Message.(*pb.IqStanza)
+		if !ok || iq.GetExtension().GetId() != constants.SelectiveAckExtension {
+			continue
+		}
+		ack := &pb.SelectiveAck{}
+		if err := proto.Unmarshal(iq.GetExtension().GetData(), ack); err == nil {
+			ids = append(ids, ack.GetId()...)
+		}
+	}
+	return ids
+}
+
+// StreamAcks returns how many StreamAcks were received
+func (s *Server) StreamAcks() int {
+	count := 0
+	for _, frame := range s.Frames() {
+		if iq, ok := frame.Message.(*pb.IqStanza); ok && iq.GetExtension().GetId() == constants.StreamAckExtension {
+			count++
+		}
+	}
+	return count
+}
+
+// Checkins returns how many GCM check-ins were received
+func (s *Server) Checkins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkins
+}
+
+// HeartbeatPings returns how many HeartbeatPings were received
+func (s *Server) HeartbeatPings() int {
+	count := 0
+	for _, frame := range s.Frames() {
+		if frame.Tag == constants.HeartbeatPingTag {
+			count++
+		}
+	}
+	return count
+}
+
+// Close stops the server and drops every connection
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	conns := append([]*Conn(nil), s.conns...)
+	s.mu.Unlock()
+
+	s.listener.Close()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	s.wg.Wait()
+	s.checkin.Close()
+}
+
+// accept serves incoming connections until the listener is closed
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		conn := &Conn{
+			server: s,
+			conn:   netConn,
+			parser: parser.NewParser(netConn),
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			netConn.Close()
+			return
+		}
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go conn.serve()
+	}
+}
+
+// addLoggedIn queues a connection that has logged in for WaitConn
+func (s *Server) addLoggedIn(conn *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loggedIn = append(s.loggedIn, conn)
+	close(s.loginSignal)
+	s.loginSignal = make(chan struct{})
+}
+
+// record stores a frame received from a client
+func (s *Server) record(frame Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frames = append(s.frames, frame)
+}
+
+// handleCheckin answers GCM check-in requests, echoing the client's IDs when present
+func (s *Server) handleCheckin(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := &pb.AndroidCheckinRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.checkins++
+	s.mu.Unlock()
+
+	androidID := req.GetId()
+	if androidID == 0 {
+		androidID = 1234567890
+	}
+	securityToken := req.GetSecurityToken()
+	if securityToken == 0 {
+		securityToken = 987654321
+	}
+
+	data, err := proto.Marshal(&pb.AndroidCheckinResponse{
+		StatsOk:       proto.Bool(true),
+		AndroidId:     proto.Uint64(uint64(androidID)),
+		SecurityToken: proto.Uint64(securityToken),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(data)
+}
+
+// Conn is a single client connection to the fake server
+type Conn struct {
+	server *Server
+	conn   net.Conn
+	parser *parser.Parser
+
+	writeMu sync.Mutex
+	// wroteVersion is set once the version byte preceding the first frame is sent
+	wroteVersion bool
+
+	mu         sync.Mutex
+	login      *pb.LoginRequest
+	frames     []Frame
+	streamIDIn int32
+}
+
+// LoginRequest returns the LoginRequest the client sent on this connection
+func (c *Conn) LoginRequest() *pb.LoginRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.login
+}
+
+// Frames returns every frame received on this connection, in order
+func (c *Conn) Frames() []Frame {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Frame(nil), c.frames...)
+}
+
+// Send writes a stanza to the client, stamping last_stream_id_received
+func (c *Conn) Send(tag uint8, msg proto.Message) error {
+	c.mu.Lock()
+	lastReceived := c.streamIDIn
+	c.mu.Unlock()
+
+	switch m := msg.(type) {
+	case *pb.HeartbeatPing:
+		m.LastStreamIdReceived = proto.Int32(lastReceived)
+	case *pb.HeartbeatAck:
+		m.LastStreamIdReceived = proto.Int32(lastReceived)
+	case *pb.IqStanza:
+		m.LastStreamIdReceived = proto.Int32(lastReceived)
+	case *pb.DataMessageStanza:
+		m.LastStreamIdReceived = proto.Int32(lastReceived)
+	case *pb.LoginResponse:
+		m.LastStreamIdReceived = proto.Int32(lastReceived)
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var buf bytes.Buffer
+	if !c.wroteVersion {
+		buf.WriteByte(constants.MCSVersion)
+		c.wroteVersion = true
+	}
+	buf.WriteByte(tag)
+	buf.Write(parser.EncodeVarint(uint32(len(data))))
+	buf.Write(data)
+
+	_, err = c.conn.Write(buf.Bytes())
+	return err
+}
+
+// SendDataMessage pushes a DataMessageStanza to the client
+func (c *Conn) SendDataMessage(msg *pb.DataMessageStanza) error {
+	return c.Send(constants.DataMessageStanzaTag, msg)
+}
+
+// SendHeartbeatPing sends a HeartbeatPing, which the client should acknowledge
+func (c *Conn) SendHeartbeatPing() error {
+	return c.Send(constants.HeartbeatPingTag, &pb.HeartbeatPing{})
+}
+
+// SendClose sends a Close stanza and drops the connection
+func (c *Conn) SendClose() error {
+	err := c.Send(constants.CloseTag, &pb.Close{})
+	c.Close()
+	return err
+}
+
+// SendStreamError sends a StreamErrorStanza and drops the connection
+func (c *Conn) SendStreamError(errorType, text string) error {
+	err := c.Send(constants.StreamErrorStanzaTag, &pb.StreamErrorStanza{
+		Type: proto.String(errorType),
+		Text: proto.String(text),
+	})
+	c.Close()
+	return err
+}
+
+// SendIq sends an IqStanza to the client
+func (c *Conn) SendIq(iq *pb.IqStanza) error {
+	return c.Send(constants.IqStanzaTag, iq)
+}
+
+// Close drops the connection without a Close stanza, as a network failure would
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// serve reads frames from the client until the connection drops
+func (c *Conn) serve() {
+	defer c.server.wg.Done()
+	defer c.conn.Close()
+
+	for {
+		msg, err := c.parser.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		frame := Frame{Tag: msg.Tag, Message: msg.Object}
+		c.mu.Lock()
+		c.streamIDIn++
+		c.frames = append(c.frames, frame)
+		c.mu.Unlock()
+		c.server.record(frame)
+
+		switch msg.Tag {
+		case constants.LoginRequestTag:
+			req, _ := msg.Object.(*pb.LoginRequest)
+			c.mu.Lock()
+			c.login = req
+			c.mu.Unlock()
+
+			resp := c.server.loginHandler(req)
+			if err := c.Send(constants.LoginResponseTag, resp); err != nil {
+				return
+			}
+			if resp.GetError() != nil {
+				return
+			}
+			// Never block here, the connection must keep answering while nobody waits for it
+			c.server.addLoggedIn(c)
+
+		case constants.HeartbeatPingTag:
+			if c.server.autoAck {
+				if err := c.Send(constants.HeartbeatAckTag, &pb.HeartbeatAck{}); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package mcstest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/internal/constants"
+	"github.com/palbooo/push-receiver-go/pkg/client"
+	"github.com/palbooo/push-receiver-go/pkg/mcstest"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// eventually fails the test if cond does not become true within five seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerLoginAndSelectiveAck(t *testing.T) {
+	server := mcstest.NewServer()
+	defer server.Close()
+
+	fcmClient := client.NewClient("1234", "5678", nil, server.ClientOptions()...)
+	if err := fcmClient.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer fcmClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := server.WaitConn(ctx)
+	if err != nil {
+		t.Fatalf("WaitConn: %v", err)
+	}
+	if got := conn.LoginRequest().GetUser(); got != "1234" {
+		t.Errorf("login user = %q, want %q", got, "1234")
+	}
+
+	if err := conn.SendDataMessage(&pb.DataMessageStanza{
+		PersistentId: proto.String("msg-1"),
+		From:         proto.String("sender"),
+		Category:     proto.String("test"),
+	}); err != nil {
+		t.Fatalf("SendDataMessage: %v", err)
+	}
+
+	for event := range fcmClient.Events() {
+		if msg, ok := event.DataMessage(); ok {
+			if msg.PersistentID != "msg-1" {
+				t.Errorf("persistent ID = %q, want %q", msg.PersistentID, "msg-1")
+			}
+			break
+		}
+	}
+
+	eventually(t, "SelectiveAck", func() bool {
+		acks := server.SelectiveAcks()
+		return len(acks) == 1 && acks[0] == "msg-1"
+	})
+}
+
+func TestServerServesConnectionsNobodyWaitsFor(t *testing.T) {
+	server := mcstest.NewServer()
+	defer server.Close()
+
+	// More logins than any fixed-size queue would hold
+	const clients = 20
+	for i := 0; i < clients; i++ {
+		opts := append(server.ClientOptions(), client.WithHeartbeatInterval(20*time.Millisecond))
+		fcmClient := client.NewClient("1234", "5678", nil, opts...)
+		if err := fcmClient.Connect(); err != nil {
+			t.Fatalf("Connect #%d: %v", i, err)
+		}
+		defer fcmClient.Close()
+	}
+
+	// Every connection keeps being read after login
+	eventually(t, "heartbeats on every connection", func() bool {
+		conns := server.Conns()
+		if len(conns) != clients {
+			return false
+		}
+		for _, conn := range conns {
+			pinged := false
+			for _, frame := range conn.Frames() {
+				pinged = pinged || frame.Tag == constants.HeartbeatPingTag
+			}
+			if !pinged {
+				return false
+			}
+		}
+		return true
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < clients; i++ {
+		if _, err := server.WaitConn(ctx); err != nil {
+			t.Fatalf("WaitConn #%d: %v", i, err)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package register
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -14,17 +16,33 @@ import (
 // AndroidFCM handles Android FCM registration
 type AndroidFCM struct {
 	config *Config
+	client *http.Client
 }
 
+// Delays of the Android registration flow, shortened by tests
+var (
+	// tokenActivationDelay is how long a new Firebase installation token takes to become usable
+	tokenActivationDelay = 2 * time.Second
+	// registerRetryStep grows the wait between register3 retries
+	registerRetryStep = 2 * time.Second
+)
+
 // NewAndroidFCM creates a new AndroidFCM instance
 func NewAndroidFCM(config *Config) *AndroidFCM {
 	return &AndroidFCM{
 		config: config,
+		client: config.httpClient(),
 	}
 }
 
 // Register performs the complete FCM registration flow
 func (a *AndroidFCM) Register() (*FCMCredentials, error) {
+	// Generate the keys notifications will be encrypted for
+	keys, err := GenerateWebPushKeys()
+	if err != nil {
+		return nil, err
+	}
+
 	// Step 1: Create Firebase installation
 	installationAuthToken, err := a.installRequest()
 	if err != nil {
@@ -33,10 +51,10 @@ func (a *AndroidFCM) Register() (*FCMCredentials, error) {
 
 	// Wait for Firebase token to propagate (prevents PHONE_REGISTRATION_ERROR)
 	fmt.Println("Waiting for Firebase token to activate...")
-	time.Sleep(2 * time.Second)
+	time.Sleep(tokenActivationDelay)
 
 	// Step 2: Check-in with GCM
-	checkInResponse, err := gcm.CheckIn("", "")
+	checkInResponse, err := gcm.CheckInContext(context.Background(), "", "", a.config.gcmOptions(a.client))
 	if err != nil {
 		return nil, fmt.Errorf("gcm check-in failed: %w", err)
 	}
@@ -58,6 +76,7 @@ func (a *AndroidFCM) Register() (*FCMCredentials, error) {
 		FCM: FCMTokenCredentials{
 			Token: fcmToken,
 		},
+		Keys: *keys,
 	}, nil
 }
 
@@ -76,8 +95,8 @@ func (a *AndroidFCM) installRequest() (string, error) {
 		return "", fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	url := fmt.Sprintf("https://firebaseinstallations.googleapis.com/v1/projects/%s/installations",
-		a.config.FCM.ProjectID)
+	url := fmt.Sprintf("%s/v1/projects/%s/installations",
+		orDefault(a.config.FirebaseInstallationsURL, DefaultFirebaseInstallationsURL), a.config.FCM.ProjectID)
 
 	responseBody, err := utils.SimpleRequest(utils.RequestOptions{
 		URL:    url,
@@ -92,7 +111,8 @@ func (a *AndroidFCM) installRequest() (string, error) {
 			"x-goog-api-key":             a.config.FCM.APIKey,
 			"User-Agent":                 "Dalvik/2.1.0 (Linux; U; Android 11; SM-A217F Build/RP1A.200720.012)",
 		},
-		Body: bodyBytes,
+		Body:   bodyBytes,
+		Client: a.client,
 	})
 	if err != nil {
 		return "", fmt.Errorf("installation request failed: %w", err)
@@ -132,13 +152,14 @@ func (a *AndroidFCM) registerRequest(androidID, securityToken, installationAuthT
 	}
 
 	responseBody, err := utils.SimpleRequest(utils.RequestOptions{
-		URL:    "https://android.clients.google.com/c2dm/register3",
+		URL:    orDefault(a.config.RegisterURL, DefaultRegisterURL),
 		Method: "POST",
 		Headers: map[string]string{
 			"Authorization": fmt.Sprintf("AidLogin %s:%s", androidID, securityToken),
 			"Content-Type":  "application/x-www-form-urlencoded",
 		},
-		Form: form,
+		Form:   form,
+		Client: a.client,
 	})
 	if err != nil {
 		return "", fmt.Errorf("register request failed: %w", err)
@@ -150,8 +171,8 @@ func (a *AndroidFCM) registerRequest(androidID, securityToken, installationAuthT
 			return "", fmt.Errorf("GCM register failed after retries: %s", response)
 		}
 
-		// Exponential backoff: 2s, 4s, 6s, 8s, 10s
-		waitTime := time.Duration(2*(retryCount+1)) * time.Second
+		// Linear backoff: 2s, 4s, 6s, 8s, 10s
+		waitTime := time.Duration(retryCount+1) * registerRetryStep
 		fmt.Printf("Register request failed with %s, retrying in %v... (attempt %d)\n", response, waitTime, retryCount+1)
 		time.Sleep(waitTime)
 		return a.registerRequest(androidID, securityToken, installationAuthToken, retryCount+1)
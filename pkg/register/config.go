@@ -1,5 +1,24 @@
 package register
 
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/palbooo/push-receiver-go/internal/gcm"
+	"github.com/palbooo/push-receiver-go/internal/proxy"
+)
+
+// Default endpoints for every remote call made during registration
+const (
+	DefaultFirebaseInstallationsURL = "https://firebaseinstallations.googleapis.com"
+	DefaultFCMRegistrationsURL      = "https://fcmregistrations.googleapis.com"
+	DefaultCheckinURL               = "https://android.clients.google.com/checkin"
+	DefaultRegisterURL              = "https://android.clients.google.com/c2dm/register3"
+	DefaultExpoPushTokenURL         = "https://exp.host/--/api/v2/push/getExpoPushToken"
+	DefaultRustPlusAPIURL           = "https://companion-rust.facepunch.com:443/api/push/register"
+)
+
 // FCMConfig contains Firebase Cloud Messaging configuration
 type FCMConfig struct {
 	APIKey             string
@@ -8,13 +27,43 @@ type FCMConfig struct {
 	GMSAppID           string
 	AndroidPackageName string
 	AndroidPackageCert string
+	// VAPIDKey is the project's public application server key, used by the web flow.
+	// When empty, FCM's default key is used.
+	VAPIDKey string
 }
 
-// Config holds all application configuration
+// Config holds all application configuration.
+// Empty URLs fall back to the matching Default*URL constant.
 type Config struct {
 	FCM              FCMConfig
 	RustPlusAPIURL   string
 	ExpoPushTokenURL string
+	// FirebaseInstallationsURL and FCMRegistrationsURL are base URLs, the project path is appended
+	FirebaseInstallationsURL string
+	FCMRegistrationsURL      string
+	// CheckinURL and RegisterURL are the GCM check-in and c2dm/register3 endpoints
+	CheckinURL  string
+	RegisterURL string
+	// Proxy routes every registration and check-in request through an HTTP, HTTPS or
+	// SOCKS5 proxy. Pass the same URL to client.WithProxy to cover the MCS connection.
+	Proxy *url.URL
+	// HTTPClient is used for every outbound request when set, taking precedence over Proxy
+	HTTPClient *http.Client
+	// CredentialStore receives the result of every successful registration when set
+	CredentialStore CredentialStore
+}
+
+// httpClient returns the HTTP client used for every registration request
+func (c *Config) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	if c.Proxy != nil {
+		return proxy.HTTPClient(c.Proxy)
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+	}
 }
 
 // DefaultConfig returns the default configuration for Rust+ Companion
@@ -28,7 +77,28 @@ func DefaultConfig() *Config {
 			AndroidPackageName: "com.facepunch.rust.companion",
 			AndroidPackageCert: "E28D05345FB78A7A1A63D70F4A302DBF426CA5AD",
 		},
-		RustPlusAPIURL:   "https://companion-rust.facepunch.com:443/api/push/register",
-		ExpoPushTokenURL: "https://exp.host/--/api/v2/push/getExpoPushToken",
+		RustPlusAPIURL:           DefaultRustPlusAPIURL,
+		ExpoPushTokenURL:         DefaultExpoPushTokenURL,
+		FirebaseInstallationsURL: DefaultFirebaseInstallationsURL,
+		FCMRegistrationsURL:      DefaultFCMRegistrationsURL,
+		CheckinURL:               DefaultCheckinURL,
+		RegisterURL:              DefaultRegisterURL,
+	}
+}
+
+// gcmOptions returns the options for GCM check-in and register calls made with client
+func (c *Config) gcmOptions(client *http.Client) gcm.Options {
+	return gcm.Options{
+		Client:      client,
+		CheckinURL:  orDefault(c.CheckinURL, DefaultCheckinURL),
+		RegisterURL: orDefault(c.RegisterURL, DefaultRegisterURL),
+	}
+}
+
+// orDefault returns value, or fallback when value is empty
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
 	}
+	return value
 }
@@ -0,0 +1,359 @@
+package register
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/palbooo/push-receiver-go/internal/utils"
+)
+
+const (
+	// envelopeVersion is the current version of the encrypted credential file format
+	envelopeVersion = 1
+	// kdfPBKDF2 names the key derivation used for passphrase keys
+	kdfPBKDF2 = "pbkdf2-sha256"
+	// defaultPBKDF2Iterations is the work factor for new passphrase-encrypted files
+	defaultPBKDF2Iterations = 600000
+	// saltSize is the length of the random PBKDF2 salt
+	saltSize = 16
+)
+
+// ErrWrongCredentialKey is returned when none of the configured keys can decrypt the file
+var ErrWrongCredentialKey = errors.New("no credential key can decrypt the stored credentials")
+
+// ErrEmptyCredentialKey is returned for a key without key material, such as the zero CredentialKey
+var ErrEmptyCredentialKey = errors.New("credential key is empty")
+
+// CredentialKey is a key for EncryptedCredentialStore, either a raw AES key or a
+// passphrase that is stretched with PBKDF2 and a per-file salt
+type CredentialKey struct {
+	// ID is written to the file so the right key is picked after a rotation
+	ID         string
+	raw        []byte
+	passphrase []byte
+}
+
+// PassphraseKey creates a key derived from a passphrase, which must not be empty
+func PassphraseKey(id, passphrase string) (CredentialKey, error) {
+	if passphrase == "" {
+		return CredentialKey{}, ErrEmptyCredentialKey
+	}
+	return CredentialKey{ID: id, passphrase: []byte(passphrase)}, nil
+}
+
+// RawKey creates a key from 16, 24 or 32 bytes of AES key material
+func RawKey(id string, key []byte) (CredentialKey, error) {
+	switch len(key) {
+	case 16, 24, 32:
+		return CredentialKey{ID: id, raw: append([]byte(nil), key...)}, nil
+	default:
+		return CredentialKey{}, fmt.Errorf("invalid key length %d, need 16, 24 or 32 bytes", len(key))
+	}
+}
+
+// check rejects a key that holds no key material
+func (k CredentialKey) check() error {
+	if len(k.raw) == 0 && len(k.passphrase) == 0 {
+		if k.ID != "" {
+			return fmt.Errorf("credential key %q: %w", k.ID, ErrEmptyCredentialKey)
+		}
+		return ErrEmptyCredentialKey
+	}
+	return nil
+}
+
+// KeyFromFile reads a key from a file. A value of the form "base64:<key>" is a raw
+// 16, 24 or 32 byte AES key in standard or URL base64; anything else is a passphrase.
+// The prefix is required, so a passphrase is never mistaken for a raw key.
+func KeyFromFile(id, path string) (CredentialKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CredentialKey{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return parseKey(id, string(data))
+}
+
+// KeyFromEnv reads a key from an environment variable, parsed like KeyFromFile
+func KeyFromEnv(id, name string) (CredentialKey, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return CredentialKey{}, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return parseKey(id, value)
+}
+
+// rawKeyPrefix marks key material as a base64 encoded raw key
+const rawKeyPrefix = "base64:"
+
+// parseKey turns key material into a raw key or a passphrase key
+func parseKey(id, material string) (CredentialKey, error) {
+	material = strings.TrimSpace(material)
+	if material == "" {
+		return CredentialKey{}, fmt.Errorf("empty key")
+	}
+
+	encoded, isRaw := strings.CutPrefix(material, rawKeyPrefix)
+	if !isRaw {
+		return PassphraseKey(id, material)
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := encoding.DecodeString(encoded); err == nil {
+			return RawKey(id, raw)
+		}
+	}
+	return CredentialKey{}, fmt.Errorf("invalid base64 in raw key")
+}
+
+// credentialEnvelope is the on-disk format of an encrypted credential file
+type credentialEnvelope struct {
+	Version int    `json:"version"`
+	KeyID   string `json:"keyId,omitempty"`
+	// KDF is set when the key was derived from a passphrase
+	KDF        *envelopeKDF `json:"kdf,omitempty"`
+	Nonce      []byte       `json:"nonce"`
+	Ciphertext []byte       `json:"ciphertext"`
+}
+
+// envelopeKDF holds the parameters needed to re-derive a passphrase key
+type envelopeKDF struct {
+	Name       string `json:"name"`
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+}
+
+// EncryptedCredentialStore keeps credentials in a file encrypted with AES-GCM.
+// New files are always written with the current key; previous keys are only
+// used to read files written before a rotation.
+type EncryptedCredentialStore struct {
+	path     string
+	current  CredentialKey
+	previous []CredentialKey
+}
+
+// NewEncryptedCredentialStore creates a store backed by the file at path.
+// Pass the keys retired by a rotation as previous so existing files still load.
+func NewEncryptedCredentialStore(path string, current CredentialKey, previous ...CredentialKey) *EncryptedCredentialStore {
+	return &EncryptedCredentialStore{
+		path:     path,
+		current:  current,
+		previous: previous,
+	}
+}
+
+// Load decrypts the credentials from the file
+func (s *EncryptedCredentialStore) Load() (*RegistrationResult, error) {
+	for _, key := range append([]CredentialKey{s.current}, s.previous...) {
+		if err := key.check(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var envelope credentialEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse credential envelope: %w", err)
+	}
+	if envelope.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported credential envelope version %d", envelope.Version)
+	}
+
+	plaintext, err := s.open(&envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	var result RegistrationResult
+	if err := json.Unmarshal(plaintext, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	return &result, nil
+}
+
+// Save encrypts the credentials with the current key and writes them with 0600 permissions
+func (s *EncryptedCredentialStore) Save(result *RegistrationResult) error {
+	if err := s.current.check(); err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	envelope := &credentialEnvelope{
+		Version: envelopeVersion,
+		KeyID:   s.current.ID,
+	}
+
+	key := s.current.raw
+	if key == nil {
+		envelope.KDF = &envelopeKDF{
+			Name:       kdfPBKDF2,
+			Salt:       make([]byte, saltSize),
+			Iterations: defaultPBKDF2Iterations,
+		}
+		if _, err := rand.Read(envelope.KDF.Salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		key = pbkdf2SHA256(s.current.passphrase, envelope.KDF.Salt, envelope.KDF.Iterations, 32)
+	}
+
+	gcm, err := newCredentialGCM(key)
+	if err != nil {
+		return err
+	}
+	envelope.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(envelope.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	envelope.Ciphertext = gcm.Seal(nil, envelope.Nonce, plaintext, envelope.additionalData())
+
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credential envelope: %w", err)
+	}
+
+	if err := utils.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	return nil
+}
+
+// Rotate re-encrypts the stored credentials with the current key.
+// It is a no-op when nothing has been saved yet.
+func (s *EncryptedCredentialStore) Rotate() error {
+	result, err := s.Load()
+	if errors.Is(err, ErrNoCredentials) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.Save(result)
+}
+
+// open decrypts an envelope with whichever configured key matches it
+func (s *EncryptedCredentialStore) open(envelope *credentialEnvelope) ([]byte, error) {
+	for _, candidate := range append([]CredentialKey{s.current}, s.previous...) {
+		// Key IDs narrow the search, keys without one are always tried
+		if envelope.KeyID != "" && candidate.ID != "" && candidate.ID != envelope.KeyID {
+			continue
+		}
+
+		key, err := envelope.key(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			continue
+		}
+
+		gcm, err := newCredentialGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(envelope.Nonce) != gcm.NonceSize() {
+			return nil, fmt.Errorf("invalid nonce length %d", len(envelope.Nonce))
+		}
+
+		if plaintext, err := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, envelope.additionalData()); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrWrongCredentialKey
+}
+
+// key returns the AES key candidate would use for this envelope, or nil if the
+// kind of key does not match how the envelope was written
+func (e *credentialEnvelope) key(candidate CredentialKey) ([]byte, error) {
+	if e.KDF == nil {
+		return candidate.raw, nil
+	}
+	if candidate.passphrase == nil {
+		return nil, nil
+	}
+	if e.KDF.Name != kdfPBKDF2 {
+		return nil, fmt.Errorf("unsupported key derivation %q", e.KDF.Name)
+	}
+	if e.KDF.Iterations <= 0 || len(e.KDF.Salt) == 0 {
+		return nil, fmt.Errorf("invalid key derivation parameters")
+	}
+	return pbkdf2SHA256(candidate.passphrase, e.KDF.Salt, e.KDF.Iterations, 32), nil
+}
+
+// additionalData binds the envelope header to the ciphertext
+func (e *credentialEnvelope) additionalData() []byte {
+	return []byte(fmt.Sprintf("push-receiver-credentials/v%d/%s", e.Version, e.KeyID))
+}
+
+// newCredentialGCM creates an AES-GCM cipher for key
+func newCredentialGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// pbkdf2SHA256 derives a key from password as specified in RFC 8018
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	key := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// MigrateCredentials copies the credentials from one store to another, for example
+// from a plaintext FileCredentialStore to an EncryptedCredentialStore
+func MigrateCredentials(from, to CredentialStore) error {
+	result, err := from.Load()
+	if err != nil {
+		return err
+	}
+	return to.Save(result)
+}
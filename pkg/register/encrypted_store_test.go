@@ -0,0 +1,110 @@
+package register
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseKeyNeverGuessesRawKeys(t *testing.T) {
+	// Valid base64 of 16 bytes, but without the prefix it is a passphrase
+	key, err := parseKey("k1", "mysupersecretpassword1")
+	if err != nil {
+		t.Fatalf("parseKey: %v", err)
+	}
+	if key.raw != nil || string(key.passphrase) != "mysupersecretpassword1" {
+		t.Errorf("parseKey used %q as a raw key", "mysupersecretpassword1")
+	}
+}
+
+func TestParseKeyRawKey(t *testing.T) {
+	material := bytes.Repeat([]byte{7}, 32)
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawURLEncoding} {
+		key, err := parseKey("k1", "base64:"+encoding.EncodeToString(material)+"\n")
+		if err != nil {
+			t.Fatalf("parseKey: %v", err)
+		}
+		if !bytes.Equal(key.raw, material) || key.passphrase != nil {
+			t.Errorf("parseKey did not return the raw key")
+		}
+	}
+}
+
+func TestParseKeyRejectsBadRawKeys(t *testing.T) {
+	for _, material := range []string{
+		"base64:not base64!",
+		"base64:" + base64.StdEncoding.EncodeToString(make([]byte, 10)),
+		"  ",
+	} {
+		if _, err := parseKey("k1", material); err == nil {
+			t.Errorf("parseKey(%q) succeeded, want an error", material)
+		}
+	}
+}
+
+func TestEncryptedCredentialStoreRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.enc")
+	oldKey, err := RawKey("old", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("RawKey: %v", err)
+	}
+	newKey, err := PassphraseKey("new", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("PassphraseKey: %v", err)
+	}
+
+	saved := &RegistrationResult{SteamID: "76561198000000000", AuthToken: "token"}
+	if err := NewEncryptedCredentialStore(path, oldKey).Save(saved); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	rotated := NewEncryptedCredentialStore(path, newKey, oldKey)
+	if err := rotated.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	loaded, err := NewEncryptedCredentialStore(path, newKey).Load()
+	if err != nil {
+		t.Fatalf("Load with the new key: %v", err)
+	}
+	if loaded.AuthToken != saved.AuthToken {
+		t.Errorf("auth token = %q, want %q", loaded.AuthToken, saved.AuthToken)
+	}
+
+	if _, err := NewEncryptedCredentialStore(path, oldKey).Load(); !errors.Is(err, ErrWrongCredentialKey) {
+		t.Errorf("Load with the retired key error = %v, want ErrWrongCredentialKey", err)
+	}
+}
+
+func TestEmptyCredentialKeysAreRejected(t *testing.T) {
+	if _, err := PassphraseKey("k1", ""); !errors.Is(err, ErrEmptyCredentialKey) {
+		t.Errorf("PassphraseKey with an empty passphrase error = %v, want ErrEmptyCredentialKey", err)
+	}
+	if _, err := RawKey("k1", nil); err == nil {
+		t.Error("RawKey with no key material succeeded")
+	}
+
+	path := filepath.Join(t.TempDir(), "credentials.enc")
+	store := NewEncryptedCredentialStore(path, CredentialKey{})
+	if err := store.Save(&RegistrationResult{AuthToken: "token"}); !errors.Is(err, ErrEmptyCredentialKey) {
+		t.Errorf("Save with the zero key error = %v, want ErrEmptyCredentialKey", err)
+	}
+	if _, err := store.Load(); !errors.Is(err, ErrEmptyCredentialKey) {
+		t.Errorf("Load with the zero key error = %v, want ErrEmptyCredentialKey", err)
+	}
+
+	// A zero key among the previous keys is a configuration error too
+	key, err := RawKey("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("RawKey: %v", err)
+	}
+	if err := NewEncryptedCredentialStore(path, key).Save(&RegistrationResult{AuthToken: "token"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := NewEncryptedCredentialStore(path, key, CredentialKey{ID: "old"}).Load(); !errors.Is(err, ErrEmptyCredentialKey) {
+		t.Errorf("Load with a zero previous key error = %v, want ErrEmptyCredentialKey", err)
+	}
+}
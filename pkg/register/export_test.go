@@ -0,0 +1,13 @@
+package register
+
+import "time"
+
+// SetRetryDelays shortens the delays of the Android registration flow for a test
+// and returns a function that restores them
+func SetRetryDelays(activation, retryStep time.Duration) (restore func()) {
+	oldActivation, oldRetryStep := tokenActivationDelay, registerRetryStep
+	tokenActivationDelay, registerRetryStep = activation, retryStep
+	return func() {
+		tokenActivationDelay, registerRetryStep = oldActivation, oldRetryStep
+	}
+}
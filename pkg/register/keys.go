@@ -0,0 +1,66 @@
+package register
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// GenerateWebPushKeys generates a P-256 keypair and a 16-byte auth secret for
+// decrypting Web Push notifications
+func GenerateWebPushKeys() (*WebPushKeys, error) {
+	privateKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ECDH key: %w", err)
+	}
+
+	authSecret := make([]byte, 16)
+	if _, err := rand.Read(authSecret); err != nil {
+		return nil, fmt.Errorf("failed to generate auth secret: %w", err)
+	}
+
+	return &WebPushKeys{
+		PublicKey:  base64.RawURLEncoding.EncodeToString(privateKey.PublicKey().Bytes()),
+		PrivateKey: base64.RawURLEncoding.EncodeToString(privateKey.Bytes()),
+		AuthSecret: base64.RawURLEncoding.EncodeToString(authSecret),
+	}, nil
+}
+
+// Decode returns the private key and auth secret in the form client.WithWebPushKeys expects
+//
+// Example:
+//
+//	privateKey, authSecret, err := result.FCMCredentials.Keys.Decode()
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fcmClient := client.NewClient(androidID, securityToken, nil,
+//	    client.WithWebPushKeys(privateKey, authSecret))
+func (k *WebPushKeys) Decode() (*ecdh.PrivateKey, []byte, error) {
+	rawPrivateKey, err := decodeBase64URL(k.PrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode private key: %w", err)
+	}
+
+	privateKey, err := ecdh.P256().NewPrivateKey(rawPrivateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	authSecret, err := decodeBase64URL(k.AuthSecret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode auth secret: %w", err)
+	}
+	if len(authSecret) != 16 {
+		return nil, nil, fmt.Errorf("auth secret must be 16 bytes, got %d", len(authSecret))
+	}
+
+	return privateKey, authSecret, nil
+}
+
+// decodeBase64URL decodes base64url data with or without padding
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
@@ -3,11 +3,11 @@ package register
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/palbooo/push-receiver-go/internal/utils"
@@ -16,6 +16,7 @@ import (
 // Service handles FCM registration operations
 type Service struct {
 	config *Config
+	client *http.Client
 }
 
 // NewService creates a new FCM registration service
@@ -25,6 +26,7 @@ func NewService(config *Config) *Service {
 	}
 	return &Service{
 		config: config,
+		client: config.httpClient(),
 	}
 }
 
@@ -82,12 +84,13 @@ func (s *Service) ExpoPushToken(fcmToken string) (string, error) {
 	}
 
 	responseBody, err := utils.SimpleRequest(utils.RequestOptions{
-		URL:    s.config.ExpoPushTokenURL,
+		URL:    orDefault(s.config.ExpoPushTokenURL, DefaultExpoPushTokenURL),
 		Method: "POST",
 		Headers: map[string]string{
 			"Content-Type": "application/json",
 		},
-		Body: bodyBytes,
+		Body:   bodyBytes,
+		Client: s.client,
 	})
 	if err != nil {
 		return "", fmt.Errorf("expo push token request failed: %w", err)
@@ -128,13 +131,8 @@ func (s *Service) RegisterRustPlus(authToken, expoPushToken string) (string, err
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Create HTTP client
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
 	// Create HTTP request
-	req, err := http.NewRequest("POST", s.config.RustPlusAPIURL, bytes.NewReader(bodyBytes))
+	req, err := http.NewRequest("POST", orDefault(s.config.RustPlusAPIURL, DefaultRustPlusAPIURL), bytes.NewReader(bodyBytes))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -143,7 +141,7 @@ func (s *Service) RegisterRustPlus(authToken, expoPushToken string) (string, err
 	req.Header.Set("Content-Type", "application/json")
 
 	// Perform request
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("rustplus registration request failed: %w", err)
 	}
@@ -208,12 +206,38 @@ func (s *Service) Register(steamID, authToken string) (*RegistrationResult, erro
 
 	fmt.Println("Successfully registered with Rust Companion API")
 
-	return &RegistrationResult{
+	result := &RegistrationResult{
 		SteamID:        steamID,
 		FCMCredentials: *fcmCredentials,
 		ExpoPushToken:  expoPushToken,
 		AuthToken:      updatedAuthToken,
 		Success:        true,
-	}, nil
+	}
+
+	if s.config.CredentialStore != nil {
+		if err := s.config.CredentialStore.Save(result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// LoadOrRegister returns the credentials saved in the configured CredentialStore,
+// registering and saving new ones only when the store is empty
+func (s *Service) LoadOrRegister(steamID, authToken string) (*RegistrationResult, error) {
+	if s.config.CredentialStore == nil {
+		return s.Register(steamID, authToken)
+	}
+
+	result, err := s.config.CredentialStore.Load()
+	if err == nil {
+		return result, nil
+	}
+	if !errors.Is(err, ErrNoCredentials) {
+		return nil, err
+	}
+
+	return s.Register(steamID, authToken)
 }
 
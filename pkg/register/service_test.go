@@ -0,0 +1,135 @@
+package register_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/palbooo/push-receiver-go/pkg/register"
+	"github.com/palbooo/push-receiver-go/pkg/registertest"
+)
+
+const (
+	testSteamID   = "76561198000000000"
+	testAuthToken = "auth-token"
+)
+
+// newBackend starts a fake backend with the registration delays shortened
+func newBackend(t *testing.T, opts ...registertest.Option) *registertest.Server {
+	t.Helper()
+	t.Cleanup(register.SetRetryDelays(0, 0))
+
+	backend := registertest.NewServer(opts...)
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestRegister(t *testing.T) {
+	backend := newBackend(t)
+	store := register.NewMemoryCredentialStore(nil)
+	config := backend.Config()
+	config.CredentialStore = store
+
+	result, err := register.NewService(config).Register(testSteamID, testAuthToken)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if result.FCMCredentials.FCM.Token != registertest.FCMToken {
+		t.Errorf("FCM token = %q, want %q", result.FCMCredentials.FCM.Token, registertest.FCMToken)
+	}
+	if result.ExpoPushToken != registertest.ExpoPushToken {
+		t.Errorf("Expo push token = %q, want %q", result.ExpoPushToken, registertest.ExpoPushToken)
+	}
+	if result.AuthToken != registertest.RustPlusAuthToken {
+		t.Errorf("auth token = %q, want %q", result.AuthToken, registertest.RustPlusAuthToken)
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatalf("store.Load: %v", err)
+	}
+	if saved.AuthToken != result.AuthToken {
+		t.Errorf("saved auth token = %q, want %q", saved.AuthToken, result.AuthToken)
+	}
+}
+
+func TestRegisterRetriesPhoneRegistrationError(t *testing.T) {
+	backend := newBackend(t, registertest.WithRegisterErrors(2))
+
+	credentials, err := register.NewAndroidFCM(backend.Config()).Register()
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if credentials.FCM.Token != registertest.FCMToken {
+		t.Errorf("FCM token = %q, want %q", credentials.FCM.Token, registertest.FCMToken)
+	}
+	if calls := backend.Calls(registertest.EndpointRegister); calls != 3 {
+		t.Errorf("register3 calls = %d, want 3", calls)
+	}
+}
+
+func TestRegisterGivesUpAfterRetries(t *testing.T) {
+	backend := newBackend(t, registertest.WithRegisterErrors(100))
+
+	_, err := register.NewAndroidFCM(backend.Config()).Register()
+	if err == nil || !strings.Contains(err.Error(), "PHONE_REGISTRATION_ERROR") {
+		t.Fatalf("Register error = %v, want PHONE_REGISTRATION_ERROR", err)
+	}
+	// The first attempt plus five retries
+	if calls := backend.Calls(registertest.EndpointRegister); calls != 6 {
+		t.Errorf("register3 calls = %d, want 6", calls)
+	}
+}
+
+func TestExpoPushTokenStatusError(t *testing.T) {
+	backend := newBackend(t, registertest.WithStatus(registertest.EndpointExpo, http.StatusInternalServerError))
+
+	_, err := register.NewService(backend.Config()).ExpoPushToken(registertest.FCMToken)
+	if err == nil {
+		t.Fatal("ExpoPushToken succeeded, want an error")
+	}
+	if !strings.Contains(err.Error(), "status 500") {
+		t.Errorf("ExpoPushToken error = %v, want it to report status 500", err)
+	}
+}
+
+func TestRegisterRustPlusEmptyToken(t *testing.T) {
+	backend := newBackend(t, registertest.WithEmptyRustPlusToken())
+
+	_, err := register.NewService(backend.Config()).RegisterRustPlus(testAuthToken, registertest.ExpoPushToken)
+	if err == nil || !strings.Contains(err.Error(), "empty authToken") {
+		t.Fatalf("RegisterRustPlus error = %v, want empty authToken", err)
+	}
+}
+
+func TestRegisterDoesNotSaveFailedRegistration(t *testing.T) {
+	backend := newBackend(t, registertest.WithStatus(registertest.EndpointRustPlus, http.StatusForbidden))
+	store := register.NewMemoryCredentialStore(nil)
+	config := backend.Config()
+	config.CredentialStore = store
+
+	if _, err := register.NewService(config).Register(testSteamID, testAuthToken); err == nil {
+		t.Fatal("Register succeeded, want an error")
+	}
+	if _, err := store.Load(); !errors.Is(err, register.ErrNoCredentials) {
+		t.Errorf("store.Load error = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestLoadOrRegisterRegistersOnce(t *testing.T) {
+	backend := newBackend(t)
+	config := backend.Config()
+	config.CredentialStore = register.NewMemoryCredentialStore(nil)
+	service := register.NewService(config)
+
+	for i := 0; i < 2; i++ {
+		if _, err := service.LoadOrRegister(testSteamID, testAuthToken); err != nil {
+			t.Fatalf("LoadOrRegister #%d: %v", i, err)
+		}
+	}
+	if calls := backend.Calls(registertest.EndpointRustPlus); calls != 1 {
+		t.Errorf("Rust+ calls = %d, want 1", calls)
+	}
+}
@@ -0,0 +1,102 @@
+package register
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/palbooo/push-receiver-go/internal/utils"
+)
+
+// ErrNoCredentials is returned by CredentialStore.Load when nothing has been saved yet
+var ErrNoCredentials = errors.New("no credentials stored")
+
+// CredentialStore loads and saves the result of a registration
+type CredentialStore interface {
+	// Load returns the saved credentials, or ErrNoCredentials if there are none
+	Load() (*RegistrationResult, error)
+	// Save replaces the saved credentials
+	Save(result *RegistrationResult) error
+}
+
+// FileCredentialStore keeps credentials in a JSON file readable only by its owner.
+// Saves are atomic, so a crash never leaves a truncated file behind.
+type FileCredentialStore struct {
+	path string
+}
+
+// NewFileCredentialStore creates a store backed by the file at path
+func NewFileCredentialStore(path string) *FileCredentialStore {
+	return &FileCredentialStore{path: path}
+}
+
+// Load reads the credentials from the file
+func (s *FileCredentialStore) Load() (*RegistrationResult, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var result RegistrationResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+	return &result, nil
+}
+
+// Save writes the credentials to the file with 0600 permissions
+func (s *FileCredentialStore) Save(result *RegistrationResult) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	if err := utils.WriteFileAtomic(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	return nil
+}
+
+// MemoryCredentialStore keeps credentials in memory, mainly for tests
+type MemoryCredentialStore struct {
+	mu     sync.Mutex
+	result *RegistrationResult
+}
+
+// NewMemoryCredentialStore creates an in-memory store, optionally holding result
+func NewMemoryCredentialStore(result *RegistrationResult) *MemoryCredentialStore {
+	s := &MemoryCredentialStore{}
+	if result != nil {
+		copied := *result
+		s.result = &copied
+	}
+	return s
+}
+
+// Load returns a copy of the stored credentials
+func (s *MemoryCredentialStore) Load() (*RegistrationResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.result == nil {
+		return nil, ErrNoCredentials
+	}
+	copied := *s.result
+	return &copied, nil
+}
+
+// Save stores a copy of the credentials
+func (s *MemoryCredentialStore) Save(result *RegistrationResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *result
+	s.result = &copied
+	return nil
+}
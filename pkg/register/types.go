@@ -13,10 +13,18 @@ type FCMTokenCredentials struct {
 	Token string `json:"token"`
 }
 
+// WebPushKeys contains the base64url encoded key material used to decrypt Web Push notifications
+type WebPushKeys struct {
+	PublicKey  string `json:"publicKey"`
+	PrivateKey string `json:"privateKey"`
+	AuthSecret string `json:"authSecret"`
+}
+
 // FCMCredentials contains both GCM and FCM credentials
 type FCMCredentials struct {
-	GCM GCMCredentials      `json:"gcm"`
-	FCM FCMTokenCredentials `json:"fcm"`
+	GCM  GCMCredentials      `json:"gcm"`
+	FCM  FCMTokenCredentials `json:"fcm"`
+	Keys WebPushKeys         `json:"keys"`
 }
 
 // RegistrationResult contains the complete FCM registration result
@@ -26,6 +34,9 @@ type RegistrationResult struct {
 	ExpoPushToken  string         `json:"expoPushToken"`
 	AuthToken      string         `json:"authToken"`
 	Success        bool           `json:"success"`
+	// Dedup holds the client's dedup cache (client.DedupCache as JSON) so that
+	// redelivered messages are still recognised after a restart
+	Dedup json.RawMessage `json:"dedup,omitempty"`
 }
 
 // ToJSON converts the RegistrationResult to a JSON string
@@ -65,6 +76,25 @@ type AuthToken struct {
 	ExpiresIn string `json:"expiresIn"`
 }
 
+// WebRegistration describes the web push subscription sent to FCM
+type WebRegistration struct {
+	ApplicationPubKey string `json:"applicationPubKey,omitempty"`
+	Auth              string `json:"auth"`
+	Endpoint          string `json:"endpoint"`
+	P256dh            string `json:"p256dh"`
+}
+
+// WebRegistrationRequest represents the request to register a web push subscription with FCM
+type WebRegistrationRequest struct {
+	Web WebRegistration `json:"web"`
+}
+
+// WebRegistrationResponse represents the response from the FCM registrations API
+type WebRegistrationResponse struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
 // ExpoPushTokenRequest represents the request to get an Expo push token
 type ExpoPushTokenRequest struct {
 	Type        string `json:"type"`
@@ -0,0 +1,206 @@
+package register
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/palbooo/push-receiver-go/internal/gcm"
+	"github.com/palbooo/push-receiver-go/internal/utils"
+)
+
+// WebFCM handles Chrome/Web FCM registration for a Firebase web app.
+// It uses APIKey, ProjectID and GMSAppID (the web app ID) from the FCM config.
+type WebFCM struct {
+	config *Config
+	client *http.Client
+}
+
+// NewWebFCM creates a new WebFCM instance
+func NewWebFCM(config *Config) *WebFCM {
+	return &WebFCM{
+		config: config,
+		client: config.httpClient(),
+	}
+}
+
+// Register performs the complete web FCM registration flow.
+// The credentials are saved to Config.CredentialStore when it is set.
+//
+// Example:
+//
+//	config := &register.Config{FCM: register.FCMConfig{
+//	    APIKey:    "AIza...",
+//	    ProjectID: "my-project",
+//	    GMSAppID:  "1:1234567890:web:abcdef",
+//	}}
+//	credentials, err := register.NewWebFCM(config).Register()
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Printf("FCM Token: %s\n", credentials.FCM.Token)
+func (w *WebFCM) Register() (*FCMCredentials, error) {
+	// Generate the keys the FCM web endpoint will encrypt notifications for
+	keys, err := GenerateWebPushKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: Check-in with GCM
+	checkInResponse, err := gcm.CheckInContext(context.Background(), "", "", w.config.gcmOptions(w.client))
+	if err != nil {
+		return nil, fmt.Errorf("gcm check-in failed: %w", err)
+	}
+
+	androidID := fmt.Sprintf("%d", checkInResponse.GetAndroidId())
+	securityToken := fmt.Sprintf("%d", checkInResponse.GetSecurityToken())
+
+	// Step 2: Register with GCM as a Chrome web push receiver
+	appID := fmt.Sprintf("wp:receiver.push.com#%s", uuid.New().String())
+	gcmToken, err := gcm.Register(androidID, securityToken, appID, w.config.gcmOptions(w.client))
+	if err != nil {
+		return nil, fmt.Errorf("gcm registration failed: %w", err)
+	}
+
+	// Step 3: Create Firebase installation
+	installationAuthToken, err := w.installRequest()
+	if err != nil {
+		return nil, fmt.Errorf("installation request failed: %w", err)
+	}
+
+	// Step 4: Subscribe the GCM endpoint to the project with our keys
+	fcmToken, err := w.registrationRequest(gcmToken, installationAuthToken, keys)
+	if err != nil {
+		return nil, fmt.Errorf("fcm registration failed: %w", err)
+	}
+
+	credentials := &FCMCredentials{
+		GCM: GCMCredentials{
+			AndroidID:     androidID,
+			SecurityToken: securityToken,
+		},
+		FCM: FCMTokenCredentials{
+			Token: fcmToken,
+		},
+		Keys: *keys,
+	}
+
+	if w.config.CredentialStore != nil {
+		result := &RegistrationResult{FCMCredentials: *credentials, Success: true}
+		if err := w.config.CredentialStore.Save(result); err != nil {
+			return nil, err
+		}
+	}
+
+	return credentials, nil
+}
+
+// LoadOrRegister returns the credentials saved in the configured CredentialStore,
+// registering and saving new ones only when the store is empty
+func (w *WebFCM) LoadOrRegister() (*FCMCredentials, error) {
+	if w.config.CredentialStore == nil {
+		return w.Register()
+	}
+
+	result, err := w.config.CredentialStore.Load()
+	if err == nil {
+		return &result.FCMCredentials, nil
+	}
+	if !errors.Is(err, ErrNoCredentials) {
+		return nil, err
+	}
+
+	return w.Register()
+}
+
+func (w *WebFCM) installRequest() (string, error) {
+	requestBody := map[string]interface{}{
+		"fid":         generateFirebaseFID(),
+		"appId":       w.config.FCM.GMSAppID,
+		"authVersion": "FIS_v2",
+		"sdkVersion":  "w:0.6.4",
+	}
+
+	bodyBytes, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/%s/installations",
+		orDefault(w.config.FirebaseInstallationsURL, DefaultFirebaseInstallationsURL), w.config.FCM.ProjectID)
+
+	responseBody, err := utils.SimpleRequest(utils.RequestOptions{
+		URL:    url,
+		Method: "POST",
+		Headers: map[string]string{
+			"Accept":         "application/json",
+			"Content-Type":   "application/json",
+			"x-goog-api-key": w.config.FCM.APIKey,
+		},
+		Body:   bodyBytes,
+		Client: w.client,
+	})
+	if err != nil {
+		return "", fmt.Errorf("installation request failed: %w", err)
+	}
+
+	var response InstallationResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if response.AuthToken.Token == "" {
+		return "", fmt.Errorf("failed to get Firebase installation AuthToken")
+	}
+
+	return response.AuthToken.Token, nil
+}
+
+func (w *WebFCM) registrationRequest(gcmToken, installationAuthToken string, keys *WebPushKeys) (string, error) {
+	requestBody := WebRegistrationRequest{
+		Web: WebRegistration{
+			ApplicationPubKey: w.config.FCM.VAPIDKey,
+			Auth:              keys.AuthSecret,
+			Endpoint:          fmt.Sprintf("https://fcm.googleapis.com/fcm/send/%s", gcmToken),
+			P256dh:            keys.PublicKey,
+		},
+	}
+
+	bodyBytes, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/%s/registrations",
+		orDefault(w.config.FCMRegistrationsURL, DefaultFCMRegistrationsURL), w.config.FCM.ProjectID)
+
+	responseBody, err := utils.SimpleRequest(utils.RequestOptions{
+		URL:    url,
+		Method: "POST",
+		Headers: map[string]string{
+			"Accept":                             "application/json",
+			"Content-Type":                       "application/json",
+			"x-goog-api-key":                     w.config.FCM.APIKey,
+			"x-goog-firebase-installations-auth": installationAuthToken,
+		},
+		Body:   bodyBytes,
+		Client: w.client,
+	})
+	if err != nil {
+		return "", fmt.Errorf("registration request failed: %w", err)
+	}
+
+	var response WebRegistrationResponse
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if response.Token == "" {
+		return "", fmt.Errorf("empty token in fcm registration response: %s", string(responseBody))
+	}
+
+	return response.Token, nil
+}
@@ -0,0 +1,71 @@
+package register_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/palbooo/push-receiver-go/pkg/register"
+	"github.com/palbooo/push-receiver-go/pkg/registertest"
+)
+
+func TestWebFCMRegisterSavesCredentials(t *testing.T) {
+	backend := newBackend(t)
+	store := register.NewMemoryCredentialStore(nil)
+	config := backend.Config()
+	config.CredentialStore = store
+
+	credentials, err := register.NewWebFCM(config).Register()
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if credentials.FCM.Token != registertest.FCMToken {
+		t.Errorf("FCM token = %q, want %q", credentials.FCM.Token, registertest.FCMToken)
+	}
+	if credentials.Keys.PrivateKey == "" || credentials.Keys.AuthSecret == "" {
+		t.Error("credentials have no web push keys")
+	}
+	// The GCM token from register3 becomes the push endpoint
+	requests := backend.Requests(registertest.EndpointRegistrations)
+	if len(requests) != 1 || !strings.Contains(string(requests[0]), "/fcm/send/fake-gcm-token") {
+		t.Errorf("registrations requests = %q, want one for fake-gcm-token", requests)
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatalf("store.Load: %v", err)
+	}
+	if saved.FCMCredentials != *credentials {
+		t.Errorf("saved credentials = %+v, want %+v", saved.FCMCredentials, *credentials)
+	}
+}
+
+func TestWebFCMLoadOrRegister(t *testing.T) {
+	backend := newBackend(t)
+	config := backend.Config()
+	config.CredentialStore = register.NewMemoryCredentialStore(nil)
+	webFCM := register.NewWebFCM(config)
+
+	first, err := webFCM.LoadOrRegister()
+	if err != nil {
+		t.Fatalf("first LoadOrRegister: %v", err)
+	}
+	second, err := webFCM.LoadOrRegister()
+	if err != nil {
+		t.Fatalf("second LoadOrRegister: %v", err)
+	}
+
+	if *second != *first {
+		t.Errorf("second LoadOrRegister = %+v, want the saved %+v", *second, *first)
+	}
+	// Only the first call registers
+	for _, endpoint := range []registertest.Endpoint{
+		registertest.EndpointCheckin,
+		registertest.EndpointRegister,
+		registertest.EndpointInstallations,
+		registertest.EndpointRegistrations,
+	} {
+		if calls := backend.Calls(endpoint); calls != 1 {
+			t.Errorf("%s calls = %d, want 1", endpoint, calls)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Package registertest provides httptest-based stand-ins for every remote service
+// used during registration: Firebase installations, FCM registrations, GCM check-in,
+// c2dm/register3, Expo and the Rust Companion API.
+//
+// Example:
+//
+//	backend := registertest.NewServer(registertest.WithRegisterErrors(2))
+//	defer backend.Close()
+//
+//	service := register.NewService(backend.Config())
+//	result, err := service.Register(steamID, authToken)
+package registertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/palbooo/push-receiver-go/pkg/register"
+	pb "github.com/palbooo/push-receiver-go/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Endpoint identifies one of the fake services
+type Endpoint string
+
+const (
+	// EndpointInstallations is the Firebase installations API
+	EndpointInstallations Endpoint = "installations"
+	// EndpointRegistrations is the FCM web registrations API
+	EndpointRegistrations Endpoint = "registrations"
+	// EndpointCheckin is the GCM check-in endpoint
+	EndpointCheckin Endpoint = "checkin"
+	// EndpointRegister is the c2dm/register3 endpoint
+	EndpointRegister Endpoint = "register3"
+	// EndpointExpo is the Expo push token API
+	EndpointExpo Endpoint = "expo"
+	// EndpointRustPlus is the Rust Companion push registration API
+	EndpointRustPlus Endpoint = "rustplus"
+)
+
+// Values returned by the fake services on success
+const (
+	AndroidID         = 1234567890
+	SecurityToken     = 987654321
+	InstallationToken = "fake-installation-token"
+	FCMToken          = "fake-fcm-token"
+	ExpoPushToken     = "ExponentPushToken[fake]"
+	RustPlusAuthToken = "fake-rustplus-token"
+)
+
+// Server is a fake backend for the whole registration flow
+type Server struct {
+	server *httptest.Server
+
+	mu                 sync.Mutex
+	calls              map[Endpoint]int
+	requests           map[Endpoint][][]byte
+	registerErrors     int
+	registerError      string
+	statuses           map[Endpoint]int
+	emptyInstallation  bool
+	emptyRustPlusToken bool
+}
+
+// Option configures the fake backend
+type Option func(*Server)
+
+// WithRegisterErrors makes the first n register3 calls answer Error=PHONE_REGISTRATION_ERROR
+func WithRegisterErrors(n int) Option {
+	return WithRegisterErrorCode(n, "PHONE_REGISTRATION_ERROR")
+}
+
+// WithRegisterErrorCode makes the first n register3 calls answer Error=code
+func WithRegisterErrorCode(n int, code string) Option {
+	return func(s *Server) {
+		s.registerErrors = n
+		s.registerError = code
+	}
+}
+
+// WithStatus makes every call to endpoint fail with the given HTTP status
+func WithStatus(endpoint Endpoint, status int) Option {
+	return func(s *Server) {
+		s.statuses[endpoint] = status
+	}
+}
+
+// WithEmptyInstallationToken makes the installations API return no auth token
+func WithEmptyInstallationToken() Option {
+	return func(s *Server) {
+		s.emptyInstallation = true
+	}
+}
+
+// WithEmptyRustPlusToken makes the Rust Companion API return an empty authToken
+func WithEmptyRustPlusToken() Option {
+	return func(s *Server) {
+		s.emptyRustPlusToken = true
+	}
+}
+
+// NewServer starts the fake backend
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		calls:    make(map[Endpoint]int),
+		requests: make(map[Endpoint][][]byte),
+		statuses: make(map[Endpoint]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/projects/{project}/installations", s.handle(EndpointInstallations, s.installations))
+	mux.HandleFunc("POST /v1/projects/{project}/registrations", s.handle(EndpointRegistrations, s.registrations))
+	mux.HandleFunc("POST /checkin", s.handle(EndpointCheckin, s.checkin))
+	mux.HandleFunc("POST /c2dm/register3", s.handle(EndpointRegister, s.register))
+	mux.HandleFunc("POST /expo", s.handle(EndpointExpo, s.expo))
+	mux.HandleFunc("POST /rustplus", s.handle(EndpointRustPlus, s.rustPlus))
+	s.server = httptest.NewServer(mux)
+
+	return s
+}
+
+// URL returns the base URL of the fake backend
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Client returns an HTTP client for the fake backend
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// Config returns the default Rust+ configuration with every URL pointed at the fake backend
+func (s *Server) Config() *register.Config {
+	config := register.DefaultConfig()
+	config.FirebaseInstallationsURL = s.URL()
+	config.FCMRegistrationsURL = s.URL()
+	config.CheckinURL = s.URL() + "/checkin"
+	config.RegisterURL = s.URL() + "/c2dm/register3"
+	config.ExpoPushTokenURL = s.URL() + "/expo"
+	config.RustPlusAPIURL = s.URL() + "/rustplus"
+	config.HTTPClient = s.Client()
+	return config
+}
+
+// Calls returns how many requests endpoint has received
+func (s *Server) Calls(endpoint Endpoint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[endpoint]
+}
+
+// Requests returns the bodies of every request endpoint has received
+func (s *Server) Requests(endpoint Endpoint) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.requests[endpoint]...)
+}
+
+// Close shuts down the fake backend
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// handle records the request and applies any configured status failure
+func (s *Server) handle(endpoint Endpoint, next func(w http.ResponseWriter, body []byte, call int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mu.Lock()
+		s.calls[endpoint]++
+		call := s.calls[endpoint]
+		s.requests[endpoint] = append(s.requests[endpoint], body)
+		status := s.statuses[endpoint]
+		s.mu.Unlock()
+
+		if status != 0 {
+			http.Error(w, fmt.Sprintf("%s failure", endpoint), status)
+			return
+		}
+
+		next(w, body, call)
+	}
+}
+
+func (s *Server) installations(w http.ResponseWriter, body []byte, call int) {
+	var response register.InstallationResponse
+	if !s.emptyInstallation {
+		response.AuthToken.Token = InstallationToken
+		response.AuthToken.ExpiresIn = "604800s"
+	}
+	writeJSON(w, response)
+}
+
+func (s *Server) registrations(w http.ResponseWriter, body []byte, call int) {
+	writeJSON(w, register.WebRegistrationResponse{Token: FCMToken})
+}
+
+func (s *Server) checkin(w http.ResponseWriter, body []byte, call int) {
+	req := &pb.AndroidCheckinRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := proto.Marshal(&pb.AndroidCheckinResponse{
+		StatsOk:       proto.Bool(true),
+		AndroidId:     proto.Uint64(AndroidID),
+		SecurityToken: proto.Uint64(SecurityToken),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(data)
+}
+
+func (s *Server) register(w http.ResponseWriter, body []byte, call int) {
+	s.mu.Lock()
+	fail := call <= s.registerErrors
+	code := s.registerError
+	s.mu.Unlock()
+
+	if fail {
+		fmt.Fprintf(w, "Error=%s", code)
+		return
+	}
+
+	token := FCMToken
+	if strings.Contains(string(body), "wp%3Areceiver.push.com") {
+		// Web registrations get a GCM token that is later exchanged with FCM
+		token = "fake-gcm-token"
+	}
+	fmt.Fprintf(w, "token=%s", token)
+}
+
+func (s *Server) expo(w http.ResponseWriter, body []byte, call int) {
+	var response register.ExpoPushTokenResponse
+	response.Data.ExpoPushToken = ExpoPushToken
+	writeJSON(w, response)
+}
+
+func (s *Server) rustPlus(w http.ResponseWriter, body []byte, call int) {
+	response := register.RustPlusResponse{AuthToken: RustPlusAuthToken}
+	if s.emptyRustPlusToken {
+		response.AuthToken = ""
+	}
+	writeJSON(w, response)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}